@@ -1,17 +1,63 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/config"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/health"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/server"
-	"log"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/tracing"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+const shutdownTimeout = 30 * time.Second
+
 func main() {
 	r, err := server.Start()
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("failed to start server", "error", err)
+		os.Exit(1)
+	}
+
+	srv := &http.Server{Addr: config.RunAddr, Handler: r}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+
+		// Fail readiness first so the orchestrator stops routing traffic here,
+		// then let in-flight requests finish.
+		slog.Info("shutting down", "delay", config.ShutdownDelay)
+		health.SetShuttingDown()
+		time.Sleep(config.ShutdownDelay)
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("failed to shut down server", "error", err)
+		}
+	}()
+
+	slog.Info("server started", "address", config.RunAddr)
+
+	err = srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("server stopped", "error", err)
+		tracing.Shutdown(context.Background())
+		os.Exit(1)
 	}
 
-	log.Fatal(http.ListenAndServe(config.RunAddr, r))
+	<-stopped
+	tracing.Shutdown(context.Background())
+	slog.Info("server stopped")
 }
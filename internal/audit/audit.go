@@ -0,0 +1,47 @@
+package audit
+
+import (
+	"context"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/clientip"
+	"net/http"
+)
+
+// maxUserAgent bounds what is kept of the User-Agent header.
+const maxUserAgent = 512
+
+type ctxKey struct{}
+
+// Source tells where a request came from. It is recorded with every audit
+// entry written while handling the request.
+type Source struct {
+	IP        string
+	UserAgent string
+}
+
+// WithSource returns a context whose audit entries are attributed to the
+// given client.
+func WithSource(ctx context.Context, ip, userAgent string) context.Context {
+	if len(userAgent) > maxUserAgent {
+		userAgent = userAgent[:maxUserAgent]
+	}
+
+	return context.WithValue(ctx, ctxKey{}, Source{IP: ip, UserAgent: userAgent})
+}
+
+// SourceFrom returns the client of the request the context belongs to. Work
+// the service does on its own has no source.
+func SourceFrom(ctx context.Context) Source {
+	s, _ := ctx.Value(ctxKey{}).(Source)
+
+	return s
+}
+
+// Middleware stores the client address and user agent of the request for the
+// audit log. It must run after the clientip middleware.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithSource(r.Context(), clientip.Get(r.Context()), r.UserAgent())
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
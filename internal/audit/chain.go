@@ -0,0 +1,46 @@
+package audit
+
+import (
+	"context"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/health"
+	"log/slog"
+	"time"
+)
+
+// chainBatch bounds the entries chained in one transaction, and so how long
+// the chain lock is held.
+const chainBatch = 100
+
+// Chainer links the pending audit entries into the hash chain.
+type Chainer interface {
+	ChainAudit(ctx context.Context, limit int) (int, error)
+}
+
+// Chain links pending entries into the audit log every interval until ctx
+// is cancelled. Replicas may all run it: the chain lock lets one at a time
+// through.
+func Chain(ctx context.Context, chainer Chainer, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	health.Register("audit_chain", interval)
+	defer health.Exit("audit_chain")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			health.Beat("audit_chain")
+			for {
+				n, err := chainer.ChainAudit(ctx, chainBatch)
+				if err != nil {
+					slog.Error("failed to chain audit entries", "error", err)
+				}
+				if err != nil || n < chainBatch {
+					break
+				}
+			}
+		}
+	}
+}
@@ -1,25 +1,53 @@
 package auth
 
 import (
+	"context"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
+	"log/slog"
+	"net/http"
 	"time"
 )
 
 const TokenExp = time.Hour * 3
+
+// MFATokenExp is how long a user has to enter the second factor.
+const MFATokenExp = time.Minute * 5
+
+// OIDCFlowExp is how long a user has to log in at the identity provider.
+const OIDCFlowExp = time.Minute * 10
+
+const purposeMFA = "mfa"
+const purposeOIDC = "oidc"
 const SecretKey = "supersecretkey"
 
 type Claims struct {
 	jwt.RegisteredClaims
 	Login string
+	// SessionVersion is the session version of the user when the token was
+	// issued. Changing the password bumps it, revoking older tokens.
+	SessionVersion int `json:",omitempty"`
+	// Purpose restricts a token to one step, such as entering the second
+	// factor. Such tokens are not accepted as sessions.
+	Purpose string    `json:",omitempty"`
+	OIDC    *OIDCFlow `json:",omitempty"`
 }
 
-func BuildJWTString(login string) (string, error) {
+// OIDCFlow is what the callback of an OpenID Connect login checks the
+// response of the provider against.
+type OIDCFlow struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+func BuildJWTString(login string, sessionVersion int) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TokenExp)),
 		},
-		Login: login,
+		Login:          login,
+		SessionVersion: sessionVersion,
 	})
 
 	tokenString, err := token.SignedString([]byte(SecretKey))
@@ -31,7 +59,91 @@ func BuildJWTString(login string) (string, error) {
 }
 
 func GetUserID(tokenString string) string {
+	claims := GetClaims(tokenString)
+	if claims == nil {
+		return ""
+	}
+
+	return claims.Login
+}
+
+type loginKey struct{}
+
+// WithLogin marks the request as made by login, for credentials other than
+// the JWT such as API keys.
+func WithLogin(ctx context.Context, login string) context.Context {
+	return context.WithValue(ctx, loginKey{}, login)
+}
+
+// RequestLogin returns the user a request was authenticated as: by an API key
+// if one was checked, otherwise by the JWT in the Authorization header.
+func RequestLogin(r *http.Request) string {
+	if login, ok := r.Context().Value(loginKey{}).(string); ok {
+		return login
+	}
+
+	return GetUserID(r.Header.Get("Authorization"))
+}
+
+// BuildMFAToken returns a short-lived token proving the password of login
+// was checked, to be exchanged for a session with a second factor.
+func BuildMFAToken(login string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(MFATokenExp)),
+		},
+		Login:   login,
+		Purpose: purposeMFA,
+	})
+
+	return token.SignedString([]byte(SecretKey))
+}
 
+// GetMFALogin returns the login of a valid token made by BuildMFAToken.
+func GetMFALogin(tokenString string) string {
+	claims := parse(tokenString)
+	if claims == nil || claims.Purpose != purposeMFA {
+		return ""
+	}
+
+	return claims.Login
+}
+
+// BuildOIDCFlowToken signs the state of an OpenID Connect login, so it can be
+// kept by the browser until the provider redirects back.
+func BuildOIDCFlowToken(flow OIDCFlow) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(OIDCFlowExp)),
+		},
+		Purpose: purposeOIDC,
+		OIDC:    &flow,
+	})
+
+	return token.SignedString([]byte(SecretKey))
+}
+
+// GetOIDCFlow returns the flow of a valid token made by BuildOIDCFlowToken.
+func GetOIDCFlow(tokenString string) *OIDCFlow {
+	claims := parse(tokenString)
+	if claims == nil || claims.Purpose != purposeOIDC {
+		return nil
+	}
+
+	return claims.OIDC
+}
+
+// GetClaims returns the claims of a valid session token, or nil.
+func GetClaims(tokenString string) *Claims {
+	claims := parse(tokenString)
+	if claims == nil || claims.Purpose != "" {
+		return nil
+	}
+
+	return claims
+}
+
+func parse(tokenString string) *Claims {
 	claims := &Claims{}
 
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
@@ -41,13 +153,13 @@ func GetUserID(tokenString string) string {
 		return []byte(SecretKey), nil
 	})
 	if err != nil {
-		return ""
+		return nil
 	}
 
 	if !token.Valid {
-		fmt.Println("token is not valid")
-		return ""
+		slog.Debug("token is not valid")
+		return nil
 	}
 
-	return claims.Login
+	return claims
 }
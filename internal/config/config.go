@@ -3,18 +3,75 @@ package config
 import (
 	"flag"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var (
 	RunAddr              string
 	DataBaseURL          string
 	AccrualSystemAddress string
+	AdminToken           string
+	AccrualWebhookSecret string
+	AccrualPollInterval  time.Duration
+	ReconcileInterval    time.Duration
+	EventSinks           []string
+	OpenAPIValidate      bool
+	GRPCAddr             string
+	TraceExporter        string
+	LogFormat            string
+	LogLevel             string
+	ShutdownDelay        time.Duration
+	RateLimits           string
+	RateLimitStore       string
+	TrustedProxies       []string
+	PasswordMinLength    int
+	PasswordMaxLength    int
+	BreachedPasswords    string
+	PasswordResetTTL     time.Duration
+	PasswordNotifier     string
+	PasswordHasher       string
+	MFAWithdrawThreshold float64
+	OIDCIssuer           string
+	OIDCClientID         string
+	OIDCClientSecret     string
+	OIDCRedirectURL      string
+	OIDCLinkExisting     bool
+	APIKeyRateLimit      string
 )
 
 func ParseFlags() {
 	flag.StringVar(&RunAddr, "a", "localhost:8080", "address and port to run server")
 	flag.StringVar(&DataBaseURL, "d", "", "postgres connection url")
 	flag.StringVar(&AccrualSystemAddress, "r", "", "accrual system address")
+	flag.StringVar(&AdminToken, "admin-token", "", "token required by the admin API")
+	flag.StringVar(&AccrualWebhookSecret, "webhook-secret", "", "secret used to sign accrual system webhooks")
+	flag.DurationVar(&AccrualPollInterval, "poll-interval", time.Second, "accrual system polling interval")
+	flag.DurationVar(&ReconcileInterval, "reconcile-interval", time.Minute, "polling interval of accrual systems that send webhooks")
+	flag.BoolVar(&OpenAPIValidate, "openapi-validate", false, "validate requests and responses against the OpenAPI document")
+	flag.StringVar(&GRPCAddr, "grpc-address", "", "separate address for the gRPC server, served alongside HTTP when empty")
+	flag.StringVar(&TraceExporter, "trace-exporter", "", "trace exporter: otlp, stdout or none")
+	flag.StringVar(&LogFormat, "log-format", "text", "log format: text or json")
+	flag.StringVar(&LogLevel, "log-level", "info", "minimum log level: debug, info, warn or error")
+	flag.DurationVar(&ShutdownDelay, "shutdown-delay", 5*time.Second, "how long readiness fails before the server stops on shutdown")
+	flag.StringVar(&RateLimits, "rate-limits", "", "rate limit policies per route group, e.g. auth=5/1m,read=50/1s:100")
+	flag.StringVar(&RateLimitStore, "rate-limit-store", "memory", "where rate limits are kept: memory or postgres")
+	flag.IntVar(&PasswordMinLength, "password-min-length", 8, "minimum password length in characters")
+	flag.IntVar(&PasswordMaxLength, "password-max-length", 128, "maximum password length in characters")
+	flag.StringVar(&BreachedPasswords, "breached-passwords", "", "file with passwords that are refused, one per line")
+	flag.DurationVar(&PasswordResetTTL, "password-reset-ttl", time.Hour, "how long a password reset token is valid")
+	flag.StringVar(&PasswordNotifier, "password-notifier", "log", "how password reset tokens are delivered: log or file:<path>")
+	flag.StringVar(&PasswordHasher, "password-hasher", "argon2id", "password hasher with optional parameters, e.g. argon2id:m=19456,t=2,p=1 or bcrypt:cost=12")
+	flag.Float64Var(&MFAWithdrawThreshold, "mfa-withdraw-threshold", 0, "withdrawals above this many points need a two-factor code, 0 disables")
+	flag.StringVar(&OIDCIssuer, "oidc-issuer", "", "OpenID Connect issuer URL, enables login through the provider")
+	flag.StringVar(&OIDCClientID, "oidc-client-id", "", "OpenID Connect client ID")
+	flag.StringVar(&OIDCClientSecret, "oidc-client-secret", "", "OpenID Connect client secret, empty for public clients")
+	flag.StringVar(&OIDCRedirectURL, "oidc-redirect-url", "", "URL of /api/user/oidc/callback as registered with the provider")
+	flag.BoolVar(&OIDCLinkExisting, "oidc-link-existing", false, "link provider identities to existing users with the same login")
+	flag.StringVar(&APIKeyRateLimit, "api-key-rate-limit", "600/1m", "default rate limit of each API key, requests/period[:burst] or off")
+	trustedProxies := flag.String("trusted-proxies", "", "comma separated proxy addresses or CIDRs whose forwarding headers are trusted")
+	eventSinks := flag.String("event-sink", "", "comma separated domain event sinks (file:<path> or http(s) URL)")
 
 	flag.Parse()
 
@@ -32,4 +89,173 @@ func ParseFlags() {
 	if envAccrualURL != "" {
 		AccrualSystemAddress = envAccrualURL
 	}
+
+	envAdminToken := os.Getenv("ADMIN_TOKEN")
+	if envAdminToken != "" {
+		AdminToken = envAdminToken
+	}
+
+	envWebhookSecret := os.Getenv("ACCRUAL_WEBHOOK_SECRET")
+	if envWebhookSecret != "" {
+		AccrualWebhookSecret = envWebhookSecret
+	}
+
+	envPollInterval := os.Getenv("ACCRUAL_POLL_INTERVAL")
+	if envPollInterval != "" {
+		if d, err := time.ParseDuration(envPollInterval); err == nil {
+			AccrualPollInterval = d
+		}
+	}
+
+	envReconcileInterval := os.Getenv("ACCRUAL_RECONCILE_INTERVAL")
+	if envReconcileInterval != "" {
+		if d, err := time.ParseDuration(envReconcileInterval); err == nil {
+			ReconcileInterval = d
+		}
+	}
+
+	envEventSinks := os.Getenv("EVENT_SINK")
+	if envEventSinks != "" {
+		*eventSinks = envEventSinks
+	}
+	for _, sink := range strings.Split(*eventSinks, ",") {
+		if sink = strings.TrimSpace(sink); sink != "" {
+			EventSinks = append(EventSinks, sink)
+		}
+	}
+
+	envOpenAPIValidate := os.Getenv("OPENAPI_VALIDATE")
+	if envOpenAPIValidate != "" {
+		OpenAPIValidate, _ = strconv.ParseBool(envOpenAPIValidate)
+	}
+
+	envGRPCAddr := os.Getenv("GRPC_ADDRESS")
+	if envGRPCAddr != "" {
+		GRPCAddr = envGRPCAddr
+	}
+
+	envTraceExporter := os.Getenv("OTEL_TRACES_EXPORTER")
+	if envTraceExporter != "" {
+		TraceExporter = envTraceExporter
+	}
+
+	envLogFormat := os.Getenv("LOG_FORMAT")
+	if envLogFormat != "" {
+		LogFormat = envLogFormat
+	}
+
+	envLogLevel := os.Getenv("LOG_LEVEL")
+	if envLogLevel != "" {
+		LogLevel = envLogLevel
+	}
+
+	envShutdownDelay := os.Getenv("SHUTDOWN_DELAY")
+	if envShutdownDelay != "" {
+		if d, err := time.ParseDuration(envShutdownDelay); err == nil {
+			ShutdownDelay = d
+		}
+	}
+
+	envRateLimits := os.Getenv("RATE_LIMITS")
+	if envRateLimits != "" {
+		RateLimits = envRateLimits
+	}
+
+	envRateLimitStore := os.Getenv("RATE_LIMIT_STORE")
+	if envRateLimitStore != "" {
+		RateLimitStore = envRateLimitStore
+	}
+
+	envTrustedProxies := os.Getenv("TRUSTED_PROXIES")
+	if envTrustedProxies != "" {
+		*trustedProxies = envTrustedProxies
+	}
+	for _, proxy := range strings.Split(*trustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			TrustedProxies = append(TrustedProxies, proxy)
+		}
+	}
+
+	envPasswordMinLength := os.Getenv("PASSWORD_MIN_LENGTH")
+	if envPasswordMinLength != "" {
+		if n, err := strconv.Atoi(envPasswordMinLength); err == nil {
+			PasswordMinLength = n
+		}
+	}
+
+	envPasswordMaxLength := os.Getenv("PASSWORD_MAX_LENGTH")
+	if envPasswordMaxLength != "" {
+		if n, err := strconv.Atoi(envPasswordMaxLength); err == nil {
+			PasswordMaxLength = n
+		}
+	}
+
+	envBreachedPasswords := os.Getenv("BREACHED_PASSWORDS_FILE")
+	if envBreachedPasswords != "" {
+		BreachedPasswords = envBreachedPasswords
+	}
+
+	envPasswordResetTTL := os.Getenv("PASSWORD_RESET_TTL")
+	if envPasswordResetTTL != "" {
+		if d, err := time.ParseDuration(envPasswordResetTTL); err == nil {
+			PasswordResetTTL = d
+		}
+	}
+
+	envPasswordNotifier := os.Getenv("PASSWORD_NOTIFIER")
+	if envPasswordNotifier != "" {
+		PasswordNotifier = envPasswordNotifier
+	}
+
+	envPasswordHasher := os.Getenv("PASSWORD_HASHER")
+	if envPasswordHasher != "" {
+		PasswordHasher = envPasswordHasher
+	}
+
+	envMFAWithdrawThreshold := os.Getenv("MFA_WITHDRAW_THRESHOLD")
+	if envMFAWithdrawThreshold != "" {
+		if f, err := strconv.ParseFloat(envMFAWithdrawThreshold, 64); err == nil {
+			MFAWithdrawThreshold = f
+		}
+	}
+
+	envOIDCIssuer := os.Getenv("OIDC_ISSUER")
+	if envOIDCIssuer != "" {
+		OIDCIssuer = envOIDCIssuer
+	}
+
+	envOIDCClientID := os.Getenv("OIDC_CLIENT_ID")
+	if envOIDCClientID != "" {
+		OIDCClientID = envOIDCClientID
+	}
+
+	envOIDCClientSecret := os.Getenv("OIDC_CLIENT_SECRET")
+	if envOIDCClientSecret != "" {
+		OIDCClientSecret = envOIDCClientSecret
+	}
+
+	envOIDCRedirectURL := os.Getenv("OIDC_REDIRECT_URL")
+	if envOIDCRedirectURL != "" {
+		OIDCRedirectURL = envOIDCRedirectURL
+	}
+
+	envOIDCLinkExisting := os.Getenv("OIDC_LINK_EXISTING")
+	if envOIDCLinkExisting != "" {
+		OIDCLinkExisting, _ = strconv.ParseBool(envOIDCLinkExisting)
+	}
+
+	envAPIKeyRateLimit := os.Getenv("API_KEY_RATE_LIMIT")
+	if envAPIKeyRateLimit != "" {
+		APIKeyRateLimit = envAPIKeyRateLimit
+	}
+
+	if AccrualPollInterval <= 0 {
+		AccrualPollInterval = time.Second
+	}
+	// Accrual systems sending webhooks, the default one with
+	// AccrualWebhookSecret or a merchant with its own secret, are only polled
+	// to reconcile missed updates.
+	if ReconcileInterval <= 0 {
+		ReconcileInterval = time.Minute
+	}
 }
@@ -0,0 +1,69 @@
+package events
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+const (
+	OrderStatusChanged  = "order"
+	BalanceChanged      = "balance"
+	WithdrawalCompleted = "withdrawal"
+)
+
+// subscriberBuffer is how many events a subscriber may lag behind before
+// newer events are dropped for it.
+const subscriberBuffer = 16
+
+// Event is a change that concerns a single user.
+type Event struct {
+	Type  string          `json:"type"`
+	Login string          `json:"login"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// Broker fans events out to the subscribers of the user they belong to.
+type Broker struct {
+	mu   sync.RWMutex
+	subs map[string]map[chan Event]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[string]map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel with the events of login and a function that
+// must be called to stop receiving them.
+func (b *Broker) Subscribe(login string) (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	b.mu.Lock()
+	if b.subs[login] == nil {
+		b.subs[login] = make(map[chan Event]struct{})
+	}
+	b.subs[login][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs[login], ch)
+		if len(b.subs[login]) == 0 {
+			delete(b.subs, login)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// Dispatch delivers the event to every subscriber of its user. Subscribers
+// that are not keeping up miss the event instead of blocking the others.
+func (b *Broker) Dispatch(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subs[e.Login] {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
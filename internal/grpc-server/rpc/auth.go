@@ -0,0 +1,107 @@
+package rpc
+
+import (
+	"context"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/apikey"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/audit"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/auth"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/grpc-server/pb"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/handlers"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/logging"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/clientip"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+type loginKey struct{}
+
+// public methods are callable without a token.
+var public = map[string]bool{
+	pb.Gophermart_Register_FullMethodName:    true,
+	pb.Gophermart_Login_FullMethodName:       true,
+	pb.Gophermart_VerifyLogin_FullMethodName: true,
+}
+
+// scopes are what an API key needs to call a method. Methods not listed only
+// accept a JWT.
+var scopes = map[string]string{
+	pb.Gophermart_UploadOrder_FullMethodName:     apikey.OrdersWrite,
+	pb.Gophermart_ListOrders_FullMethodName:      apikey.OrdersRead,
+	pb.Gophermart_GetBalance_FullMethodName:      apikey.BalanceRead,
+	pb.Gophermart_Withdraw_FullMethodName:        apikey.BalanceWithdraw,
+	pb.Gophermart_ListWithdrawals_FullMethodName: apikey.BalanceRead,
+}
+
+// KeyAuthenticator checks API keys and their rate limits.
+type KeyAuthenticator interface {
+	Authenticate(ctx context.Context, token, scope string) (postgres.APIKey, error)
+	Take(ctx context.Context, key postgres.APIKey) error
+}
+
+// authenticate checks the JWT passed in the "authorization" metadata key, the
+// same token the REST API returns in the Authorization header, and that it
+// was not revoked by a password change. API keys are accepted there too.
+func authenticate(sessions handlers.SessionVersionGetter, keys KeyAuthenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+
+		ctx = logging.With(ctx, "method", info.FullMethod)
+		ctx = audit.WithSource(ctx, clientip.Get(ctx), strings.Join(md.Get("user-agent"), " "))
+		if public[info.FullMethod] {
+			return handler(ctx, req)
+		}
+
+		values := md.Get("authorization")
+		if len(values) == 0 {
+			return nil, status.Error(codes.Unauthenticated, "user not authorized")
+		}
+
+		token := strings.TrimPrefix(values[0], "Bearer ")
+		if apikey.IsKey(token) {
+			scope, ok := scopes[info.FullMethod]
+			if !ok {
+				return nil, statusError(ctx, storage.ErrInsufficientScope)
+			}
+
+			key, err := keys.Authenticate(ctx, token, scope)
+			if err != nil {
+				return nil, statusError(ctx, err)
+			}
+			if err := keys.Take(ctx, key); err != nil {
+				return nil, statusError(ctx, err)
+			}
+
+			ctx = logging.With(ctx, "user", key.Login, "api_key", key.ID)
+
+			return handler(context.WithValue(ctx, loginKey{}, key.Login), req)
+		}
+
+		claims := auth.GetClaims(token)
+		if claims == nil || claims.Login == "" {
+			return nil, status.Error(codes.Unauthenticated, "user not authorized")
+		}
+
+		version, err := sessions.GetSessionVersion(ctx, claims.Login)
+		if err != nil {
+			return nil, statusError(ctx, err)
+		}
+		if version != claims.SessionVersion {
+			return nil, statusError(ctx, storage.ErrSessionRevoked)
+		}
+
+		ctx = logging.With(ctx, "user", claims.Login)
+
+		return handler(context.WithValue(ctx, loginKey{}, claims.Login), req)
+	}
+}
+
+func userLogin(ctx context.Context) string {
+	login, _ := ctx.Value(loginKey{}).(string)
+
+	return login
+}
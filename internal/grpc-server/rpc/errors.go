@@ -0,0 +1,56 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/validation"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log/slog"
+)
+
+// known maps the storage sentinel errors to gRPC statuses, mirroring the
+// problem responses of the REST API.
+var known = []struct {
+	err     error
+	code    codes.Code
+	message string
+}{
+	{storage.ErrLoginAlreadyExists, codes.AlreadyExists, "user already exists"},
+	{storage.ErrUserNotFound, codes.Unauthenticated, "invalid login or password"},
+	{storage.ErrIncorrectPassword, codes.Unauthenticated, "invalid login or password"},
+	{storage.ErrOrderAlreadyLoadedByAnotherUser, codes.AlreadyExists, "order already loaded by another user"},
+	{storage.ErrOrderNotFound, codes.NotFound, "order not found"},
+	{storage.ErrNotEnoughBalance, codes.FailedPrecondition, "not enough balance"},
+	{storage.ErrInvalidSum, codes.InvalidArgument, "withdrawal sum must be positive"},
+	{storage.ErrTooManyRequests, codes.ResourceExhausted, "too many requests"},
+	{storage.ErrMerchantNotFound, codes.InvalidArgument, "unknown merchant"},
+	{storage.ErrLoginLocked, codes.ResourceExhausted, "too many failed login attempts"},
+	{storage.ErrSessionRevoked, codes.Unauthenticated, "session has been revoked, log in again"},
+	{storage.ErrInvalidMFACode, codes.Unauthenticated, "invalid two-factor code"},
+	{storage.ErrMFANotEnrolled, codes.FailedPrecondition, "two-factor authentication is not enabled"},
+	{storage.ErrMFARequired, codes.PermissionDenied, "two-factor confirmation required, set mfa_code"},
+	{storage.ErrInvalidAPIKey, codes.Unauthenticated, "api key is invalid or revoked"},
+	{storage.ErrInsufficientScope, codes.PermissionDenied, "api key is not allowed to do this"},
+	{storage.ErrAccountDisabled, codes.PermissionDenied, "account has been disabled"},
+}
+
+// statusError converts a storage error into a gRPC status. Unexpected errors
+// are logged and reported without details.
+func statusError(ctx context.Context, err error) error {
+	var weak *validation.PasswordError
+	if errors.As(err, &weak) {
+		return status.Error(codes.InvalidArgument, weak.Reason)
+	}
+
+	for _, k := range known {
+		if errors.Is(err, k.err) {
+			return status.Error(k.code, k.message)
+		}
+	}
+
+	slog.ErrorContext(ctx, "grpc call failed", "error", err)
+
+	return status.Error(codes.Internal, "internal server error")
+}
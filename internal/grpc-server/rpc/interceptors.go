@@ -0,0 +1,101 @@
+package rpc
+
+import (
+	"context"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/grpc-server/pb"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/metrics"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/clientip"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/ratelimit"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/requestid"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// groups are the rate limit groups of the methods, those of the matching
+// REST routes, so gRPC is no way around the HTTP limits.
+var groups = map[string]string{
+	pb.Gophermart_Register_FullMethodName:        ratelimit.GroupAuth,
+	pb.Gophermart_Login_FullMethodName:           ratelimit.GroupAuth,
+	pb.Gophermart_VerifyLogin_FullMethodName:     ratelimit.GroupAuth,
+	pb.Gophermart_UploadOrder_FullMethodName:     ratelimit.GroupWrite,
+	pb.Gophermart_Withdraw_FullMethodName:        ratelimit.GroupWithdraw,
+	pb.Gophermart_ListOrders_FullMethodName:      ratelimit.GroupRead,
+	pb.Gophermart_GetBalance_FullMethodName:      ratelimit.GroupRead,
+	pb.Gophermart_ListWithdrawals_FullMethodName: ratelimit.GroupRead,
+}
+
+// ClientResolver finds the address of a client behind trusted proxies.
+type ClientResolver interface {
+	Resolve(remoteAddr, forwardedFor, realIP string) string
+}
+
+// GroupLimiter counts requests against the policies of route groups.
+type GroupLimiter interface {
+	TakeGroup(ctx context.Context, group, login, ip string) (ratelimit.Result, error)
+}
+
+// instrument does for gRPC calls what the HTTP middleware does for requests:
+// it tags them with a request ID, resolves the client address through the
+// trusted proxies and records metrics.
+func instrument(clients ClientResolver) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		md, _ := metadata.FromIncomingContext(ctx)
+
+		ctx, id := requestid.With(ctx, first(md, "x-request-id"))
+		grpc.SetHeader(ctx, metadata.Pairs("x-request-id", id))
+
+		var remoteAddr string
+		if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+			remoteAddr = p.Addr.String()
+		}
+		ip := clients.Resolve(remoteAddr, strings.Join(md.Get("x-forwarded-for"), ","), first(md, "x-real-ip"))
+		ctx = clientip.WithIP(ctx, ip)
+
+		resp, err := handler(ctx, req)
+		metrics.ObserveGRPCRequest(info.FullMethod, status.Code(err).String(), time.Since(start))
+
+		return resp, err
+	}
+}
+
+// limit applies the rate limit group of the method, per user once
+// authenticate found one and per client IP otherwise. If the limiter fails
+// the call is let through, as with HTTP routes.
+func limit(limiter GroupLimiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		group, ok := groups[info.FullMethod]
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		res, err := limiter.TakeGroup(ctx, group, userLogin(ctx), clientip.Get(ctx))
+		if err != nil {
+			slog.ErrorContext(ctx, "rate limiter failed", "error", err)
+			return handler(ctx, req)
+		}
+		if !res.Allowed {
+			retryAfter := int(math.Ceil(res.RetryAfter.Seconds()))
+			grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(retryAfter)))
+			return nil, statusError(ctx, storage.ErrTooManyRequests)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+func first(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
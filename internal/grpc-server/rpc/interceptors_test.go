@@ -0,0 +1,65 @@
+package rpc
+
+import (
+	"context"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/grpc-server/pb"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/clientip"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/ratelimit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+)
+
+func TestInterceptorsLimitClientsBehindProxies(t *testing.T) {
+	clients, err := clientip.NewResolver([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter, err := ratelimit.New(ratelimit.NewMemoryStore(ratelimit.MemoryCapacity), "auth=2/1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := &grpc.UnaryServerInfo{FullMethod: pb.Gophermart_Login_FullMethodName}
+	proxy := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 50000}
+
+	call := func(forwardedFor string) (string, error) {
+		t.Helper()
+
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: proxy})
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-forwarded-for", forwardedFor))
+
+		var ip string
+		_, err := instrument(clients)(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+			return limit(limiter)(ctx, req, info, func(ctx context.Context, _ any) (any, error) {
+				ip = clientip.Get(ctx)
+				return nil, nil
+			})
+		})
+
+		return ip, err
+	}
+
+	for i := 0; i < 2; i++ {
+		ip, err := call("203.0.113.7, 10.0.0.2")
+		if err != nil {
+			t.Fatalf("call %d: %v", i+1, err)
+		}
+		if ip != "203.0.113.7" {
+			t.Errorf("client ip = %q, want the address forwarded by the proxies", ip)
+		}
+	}
+
+	if _, err := call("203.0.113.7"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("call over the limit: err = %v, want %v", err, codes.ResourceExhausted)
+	}
+
+	// Another client behind the same proxy has its own bucket.
+	if _, err := call("203.0.113.8"); err != nil {
+		t.Errorf("another client: %v", err)
+	}
+}
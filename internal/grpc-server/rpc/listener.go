@@ -0,0 +1,22 @@
+package rpc
+
+import (
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"net/http"
+	"strings"
+)
+
+// Handler serves gRPC calls and HTTP requests on the same listener. gRPC
+// needs HTTP/2, so cleartext HTTP/2 is accepted alongside HTTP/1.1.
+func Handler(grpcServer *grpc.Server, next http.Handler) http.Handler {
+	return h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			grpcServer.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}), &http2.Server{})
+}
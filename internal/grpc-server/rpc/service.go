@@ -0,0 +1,349 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/auth"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/grpc-server/pb"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/handlers"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/handlers/balance"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/handlers/orders"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/mfa"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/clientip"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/pagination"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/tracing"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/validation"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"strconv"
+)
+
+// Storage is what the gRPC API needs, the union of the REST handler
+// interfaces for the same operations.
+type Storage interface {
+	handlers.UserSaver
+	handlers.Authenticator
+	handlers.MFALoginVerifier
+	orders.OrderLoader
+	orders.OrderGetter
+	balance.UserBalanceGetter
+	balance.UserBalanceWithdraw
+}
+
+type service struct {
+	pb.UnimplementedGophermartServer
+	storage      Storage
+	mfaThreshold float64
+}
+
+// NewServer returns a gRPC server with the Gophermart service and server
+// reflection registered. Calls are traced, counted in the metrics and rate
+// limited with the policies of the matching REST routes, the client address
+// being resolved by clients. Withdrawals above mfaThreshold need a
+// two-factor code.
+func NewServer(storage Storage, keys KeyAuthenticator, limiter GroupLimiter, clients ClientResolver, mfaThreshold float64) *grpc.Server {
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		tracing.UnaryServerInterceptor(),
+		instrument(clients),
+		authenticate(storage, keys),
+		limit(limiter),
+	))
+	pb.RegisterGophermartServer(s, &service{storage: storage, mfaThreshold: mfaThreshold})
+	reflection.Register(s)
+
+	return s
+}
+
+func (s *service) Register(ctx context.Context, req *pb.Credentials) (*pb.Token, error) {
+	if req.GetLogin() == "" || req.GetPassword() == "" {
+		return nil, status.Error(codes.InvalidArgument, "login and password are required")
+	}
+
+	if err := s.storage.SaveUser(ctx, req.GetLogin(), req.GetPassword()); err != nil {
+		return nil, statusError(ctx, err)
+	}
+
+	return token(ctx, req.GetLogin(), 0)
+}
+
+func (s *service) Login(ctx context.Context, req *pb.Credentials) (*pb.Token, error) {
+	if req.GetLogin() == "" || req.GetPassword() == "" {
+		return nil, status.Error(codes.InvalidArgument, "login and password are required")
+	}
+
+	if err := handlers.Authenticate(ctx, s.storage, req.GetLogin(), req.GetPassword(), clientip.Get(ctx)); err != nil {
+		return nil, statusError(ctx, err)
+	}
+
+	enabled, err := mfa.Enabled(ctx, s.storage, req.GetLogin())
+	if err != nil {
+		return nil, statusError(ctx, err)
+	}
+	if enabled {
+		mfaToken, err := auth.BuildMFAToken(req.GetLogin())
+		if err != nil {
+			return nil, statusError(ctx, err)
+		}
+
+		return &pb.Token{MfaRequired: true, MfaToken: mfaToken}, nil
+	}
+
+	return s.session(ctx, req.GetLogin())
+}
+
+func (s *service) VerifyLogin(ctx context.Context, req *pb.VerifyLoginRequest) (*pb.Token, error) {
+	login := auth.GetMFALogin(req.GetMfaToken())
+	if login == "" {
+		return nil, status.Error(codes.Unauthenticated, "mfa token is invalid or expired")
+	}
+
+	if err := mfa.Confirm(ctx, s.storage, login, req.GetCode(), clientip.Get(ctx)); err != nil {
+		return nil, statusError(ctx, err)
+	}
+
+	return s.session(ctx, login)
+}
+
+// session completes a login.
+func (s *service) session(ctx context.Context, login string) (*pb.Token, error) {
+	if err := s.storage.RecordLoginSuccess(ctx, login); err != nil {
+		return nil, statusError(ctx, err)
+	}
+
+	version, err := s.storage.GetSessionVersion(ctx, login)
+	if err != nil {
+		return nil, statusError(ctx, err)
+	}
+
+	return token(ctx, login, version)
+}
+
+func (s *service) UploadOrder(ctx context.Context, req *pb.UploadOrderRequest) (*pb.UploadOrderResponse, error) {
+	if err := checkOrderNumber(req.GetNumber()); err != nil {
+		return nil, err
+	}
+
+	merchantID, err := s.merchantID(ctx, req.GetMerchant())
+	if err != nil {
+		return nil, statusError(ctx, err)
+	}
+
+	err = s.storage.LoadOrder(ctx, userLogin(ctx), req.GetNumber(), merchantID)
+	if err != nil {
+		if errors.Is(err, storage.ErrOrderAlreadyLoadedByUser) {
+			return &pb.UploadOrderResponse{AlreadyUploaded: true}, nil
+		}
+
+		return nil, statusError(ctx, err)
+	}
+
+	return &pb.UploadOrderResponse{}, nil
+}
+
+func (s *service) ListOrders(ctx context.Context, req *pb.ListOrdersRequest) (*pb.ListOrdersResponse, error) {
+	filter, err := listFilter(req.GetPage())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, st := range req.GetStatuses() {
+		if !contains(orders.Statuses, st) {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown status %q", st)
+		}
+	}
+	filter.Statuses = req.GetStatuses()
+
+	limit := filter.Limit
+	if limit > 0 {
+		filter.Limit++
+	}
+
+	list, err := s.storage.GetOrders(ctx, userLogin(ctx), filter)
+	if err != nil && !errors.Is(err, storage.ErrNoOrders) {
+		return nil, statusError(ctx, err)
+	}
+
+	resp := &pb.ListOrdersResponse{}
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+		last := list[limit-1]
+		resp.NextCursor = pagination.EncodeCursor(postgres.Cursor{At: last.UploadedAt, ID: last.ID})
+	}
+
+	for _, o := range list {
+		resp.Orders = append(resp.Orders, &pb.Order{
+			Number:     o.Number,
+			Status:     o.Status,
+			Accrual:    o.Accrual,
+			UploadedAt: timestamppb.New(o.UploadedAt),
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *service) GetBalance(ctx context.Context, _ *emptypb.Empty) (*pb.Balance, error) {
+	b, err := s.storage.GetBalance(ctx, userLogin(ctx))
+	if err != nil {
+		return nil, statusError(ctx, err)
+	}
+
+	return &pb.Balance{Current: b.Current, Withdrawn: b.Withdrawn}, nil
+}
+
+func (s *service) Withdraw(ctx context.Context, req *pb.WithdrawRequest) (*emptypb.Empty, error) {
+	if err := checkOrderNumber(req.GetOrder()); err != nil {
+		return nil, err
+	}
+	if !validation.ValidSum(req.GetSum()) {
+		return nil, statusError(ctx, storage.ErrInvalidSum)
+	}
+
+	merchantID, err := s.merchantID(ctx, req.GetMerchant())
+	if err != nil {
+		return nil, statusError(ctx, err)
+	}
+
+	login := userLogin(ctx)
+
+	if s.mfaThreshold > 0 && req.GetSum() > s.mfaThreshold {
+		enabled, err := mfa.Enabled(ctx, s.storage, login)
+		if err != nil {
+			return nil, statusError(ctx, err)
+		}
+		if !enabled || req.GetMfaCode() == "" {
+			return nil, statusError(ctx, storage.ErrMFARequired)
+		}
+
+		if err := mfa.Confirm(ctx, s.storage, login, req.GetMfaCode(), clientip.Get(ctx)); err != nil {
+			return nil, statusError(ctx, err)
+		}
+	}
+
+	err = s.storage.RequestWithdraw(ctx, login, req.GetSum(), req.GetOrder(), merchantID)
+	if err != nil {
+		return nil, statusError(ctx, err)
+	}
+
+	err = s.storage.LoadOrder(ctx, login, req.GetOrder(), merchantID)
+	if err != nil && !errors.Is(err, storage.ErrOrderAlreadyLoadedByUser) {
+		return nil, statusError(ctx, err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *service) ListWithdrawals(ctx context.Context, req *pb.ListWithdrawalsRequest) (*pb.ListWithdrawalsResponse, error) {
+	filter, err := listFilter(req.GetPage())
+	if err != nil {
+		return nil, err
+	}
+
+	limit := filter.Limit
+	if limit > 0 {
+		filter.Limit++
+	}
+
+	list, err := s.storage.GetWithdrawals(ctx, userLogin(ctx), filter)
+	if err != nil && !errors.Is(err, storage.ErrNoWithdrawalsFound) {
+		return nil, statusError(ctx, err)
+	}
+
+	resp := &pb.ListWithdrawalsResponse{}
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+		last := list[limit-1]
+		resp.NextCursor = pagination.EncodeCursor(postgres.Cursor{At: last.ProcessedAt, ID: last.ID})
+	}
+
+	for _, w := range list {
+		resp.Withdrawals = append(resp.Withdrawals, &pb.Withdrawal{
+			Order:       w.OrderID,
+			Sum:         w.Sum,
+			ProcessedAt: timestamppb.New(w.ProcessedAt),
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *service) merchantID(ctx context.Context, name string) (int64, error) {
+	if name == "" {
+		return 0, nil
+	}
+
+	merchant, err := s.storage.GetMerchantByName(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+
+	return merchant.ID, nil
+}
+
+func token(ctx context.Context, login string, sessionVersion int) (*pb.Token, error) {
+	tokenString, err := auth.BuildJWTString(login, sessionVersion)
+	if err != nil {
+		return nil, statusError(ctx, err)
+	}
+
+	return &pb.Token{Token: tokenString}, nil
+}
+
+func checkOrderNumber(number string) error {
+	if number == "" {
+		return status.Error(codes.InvalidArgument, "no order ID provided")
+	}
+
+	intOrderID, _ := strconv.Atoi(number)
+	if !validation.Valid(intOrderID) {
+		return status.Error(codes.InvalidArgument, "invalid order ID")
+	}
+
+	return nil
+}
+
+// listFilter applies the same rules as pagination.ParseFilter to a page
+// message.
+func listFilter(page *pb.Page) (postgres.ListFilter, error) {
+	filter := postgres.ListFilter{Desc: !page.GetAscending()}
+
+	limit := int(page.GetLimit())
+	if limit < 0 || limit > pagination.MaxLimit {
+		return postgres.ListFilter{}, status.Error(codes.InvalidArgument, fmt.Sprintf("limit must be between 1 and %d", pagination.MaxLimit))
+	}
+	filter.Limit = limit
+
+	if page.GetCursor() != "" {
+		cursor, err := pagination.DecodeCursor(page.GetCursor())
+		if err != nil {
+			return postgres.ListFilter{}, status.Error(codes.InvalidArgument, err.Error())
+		}
+		filter.After = &cursor
+	}
+
+	if page.GetFrom() != nil {
+		filter.From = page.GetFrom().AsTime()
+	}
+	if page.GetTo() != nil {
+		filter.To = page.GetTo().AsTime()
+	}
+
+	return filter, nil
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,21 @@
+package rpc
+
+import (
+	"context"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/grpc-server/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math"
+	"testing"
+)
+
+func TestWithdrawRejectsInvalidSum(t *testing.T) {
+	s := &service{}
+
+	for _, sum := range []float64{0, -10, math.NaN(), math.Inf(1)} {
+		_, err := s.Withdraw(context.Background(), &pb.WithdrawRequest{Order: "2377225624", Sum: sum})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("sum %v: err = %v, want %v", sum, err, codes.InvalidArgument)
+		}
+	}
+}
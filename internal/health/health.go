@@ -0,0 +1,222 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+
+	accrualTTL     = 30 * time.Second
+	accrualTimeout = 2 * time.Second
+
+	// A worker is stale after missing three ticks; the slack covers slow runs.
+	missedBeats = 3
+	beatSlack   = 30 * time.Second
+)
+
+// Check is the outcome of a single readiness check.
+type Check struct {
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+	LastBeat  *time.Time `json:"last_beat,omitempty"`
+	Version   int        `json:"version,omitempty"`
+}
+
+// Report is the readiness of the service with the checks it is made of.
+type Report struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+}
+
+type Database interface {
+	Ping(ctx context.Context) error
+	GetSchemaVersion(ctx context.Context) (int, error)
+}
+
+type worker struct {
+	maxAge time.Duration
+	last   time.Time
+	exited bool
+}
+
+var (
+	mu      sync.Mutex
+	workers = make(map[string]*worker)
+
+	shuttingDown atomic.Bool
+)
+
+// Register adds a background worker that beats every interval; the service
+// is not ready once its heartbeat goes stale. Registration counts as the
+// first beat.
+func Register(name string, interval time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	workers[name] = &worker{maxAge: missedBeats*interval + beatSlack, last: time.Now()}
+}
+
+// Beat records that the worker is still running.
+func Beat(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if w, ok := workers[name]; ok {
+		w.last = time.Now()
+	}
+}
+
+// Exit records that the worker stopped. Unless the service is shutting down
+// this fails liveness: the worker is not coming back, so the process should
+// be restarted rather than left unready.
+func Exit(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if w, ok := workers[name]; ok {
+		w.exited = true
+	}
+}
+
+// Live reports whether the process should keep running. Only exited
+// workers fail it, so a slow dependency never gets the service restarted.
+func Live() Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]Check)}
+	if shuttingDown.Load() {
+		return report
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for name, w := range workers {
+		if w.exited {
+			report.Status = StatusFail
+			report.Checks["worker:"+name] = Check{Status: StatusFail, Error: "worker exited"}
+		}
+	}
+
+	return report
+}
+
+// SetShuttingDown makes readiness fail so traffic is drained before the
+// server stops.
+func SetShuttingDown() {
+	shuttingDown.Store(true)
+}
+
+// Checker reports whether the service can take traffic.
+type Checker struct {
+	db         Database
+	schema     int
+	accrualURL string
+	client     *http.Client
+
+	mu             sync.Mutex
+	accrualChecked time.Time
+	accrualErr     error
+}
+
+// NewChecker checks the database, that its schema is at the given version
+// and, unless accrualURL is empty, that the accrual system answers. Accrual
+// results are cached, so probes do not load a system outside our control.
+func NewChecker(db Database, schemaVersion int, accrualURL string) *Checker {
+	return &Checker{
+		db:         db,
+		schema:     schemaVersion,
+		accrualURL: accrualURL,
+		client:     &http.Client{Timeout: accrualTimeout},
+	}
+}
+
+func (c *Checker) Check(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]Check)}
+	add := func(name string, check Check) {
+		if check.Status != StatusOK {
+			report.Status = StatusFail
+		}
+		report.Checks[name] = check
+	}
+
+	if shuttingDown.Load() {
+		add("shutdown", Check{Status: StatusFail, Error: "server is shutting down"})
+	}
+
+	add("database", result(c.db.Ping(ctx)))
+
+	version, err := c.db.GetSchemaVersion(ctx)
+	schema := result(err)
+	schema.Version = version
+	if err == nil && version != c.schema {
+		schema = Check{Status: StatusFail, Version: version, Error: fmt.Sprintf("expected schema version %d", c.schema)}
+	}
+	add("schema", schema)
+
+	if c.accrualURL != "" {
+		checkedAt, err := c.accrual(ctx)
+		check := result(err)
+		check.CheckedAt = &checkedAt
+		add("accrual", check)
+	}
+
+	mu.Lock()
+	now := time.Now()
+	for name, w := range workers {
+		last := w.last
+		check := Check{Status: StatusOK, LastBeat: &last}
+		if w.exited {
+			check.Status = StatusFail
+			check.Error = "worker exited"
+		} else if now.Sub(last) > w.maxAge {
+			check.Status = StatusFail
+			check.Error = fmt.Sprintf("no heartbeat for %s", now.Sub(last).Round(time.Second))
+		}
+		add("worker:"+name, check)
+	}
+	mu.Unlock()
+
+	return report
+}
+
+// accrual returns the cached reachability of the accrual system, refreshing
+// it when it is older than accrualTTL. Any HTTP response counts as reachable.
+func (c *Checker) accrual(ctx context.Context) (time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.accrualChecked) < accrualTTL {
+		return c.accrualChecked, c.accrualErr
+	}
+
+	c.accrualErr = nil
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.accrualURL, nil)
+	if err == nil {
+		var res *http.Response
+		res, err = c.client.Do(req)
+		if err == nil {
+			res.Body.Close()
+		}
+	}
+	if err != nil {
+		c.accrualErr = fmt.Errorf("accrual system is unreachable: %w", err)
+	}
+	c.accrualChecked = time.Now()
+
+	return c.accrualChecked, c.accrualErr
+}
+
+func result(err error) Check {
+	if err != nil {
+		return Check{Status: StatusFail, Error: err.Error()}
+	}
+
+	return Check{Status: StatusOK}
+}
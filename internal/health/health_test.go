@@ -0,0 +1,32 @@
+package health
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLive(t *testing.T) {
+	t.Cleanup(func() {
+		mu.Lock()
+		workers = make(map[string]*worker)
+		mu.Unlock()
+		shuttingDown.Store(false)
+	})
+
+	Register("poller", time.Second)
+	Beat("poller")
+	if report := Live(); report.Status != StatusOK {
+		t.Fatalf("live with a running worker: %+v", report)
+	}
+
+	Exit("poller")
+	report := Live()
+	if report.Status != StatusFail || report.Checks["worker:poller"].Status != StatusFail {
+		t.Fatalf("live after the worker exited: %+v", report)
+	}
+
+	SetShuttingDown()
+	if report := Live(); report.Status != StatusOK {
+		t.Fatalf("live while shutting down: %+v", report)
+	}
+}
@@ -0,0 +1,54 @@
+package admin
+
+import (
+	"context"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/pagination"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"net/http"
+)
+
+// defaultAuditLimit caps pages of the audit log when no limit is given.
+const defaultAuditLimit = 100
+
+type AuditGetter interface {
+	GetAudit(ctx context.Context, q postgres.AuditQuery, filter postgres.ListFilter) ([]postgres.AuditEntry, error)
+}
+
+// GetAuditHandle searches the audit log by actor, action and target, with the
+// time range and pagination of the other lists.
+func GetAuditHandle(getter AuditGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := pagination.ParseFilter(r, nil)
+		if err != nil {
+			problem.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if filter.Limit == 0 {
+			filter.Limit = defaultAuditLimit
+		}
+
+		limit := filter.Limit
+		filter.Limit++
+
+		q := r.URL.Query()
+		entries, err := getter.GetAudit(r.Context(), postgres.AuditQuery{
+			Actor:  q.Get("actor"),
+			Action: q.Get("action"),
+			Target: q.Get("target"),
+		}, filter)
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+
+		if len(entries) > limit {
+			entries = entries[:limit]
+			last := entries[limit-1]
+			pagination.SetNextLink(w, r, postgres.Cursor{At: last.CreatedAt, ID: last.ID})
+		}
+
+		writeJSON(w, r, http.StatusOK, entries)
+	}
+}
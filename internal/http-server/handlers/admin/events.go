@@ -0,0 +1,69 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultEventsLimit = 100
+	maxEventsLimit     = 1000
+)
+
+type EventsGetter interface {
+	GetEvents(ctx context.Context, after postgres.EventCursor, limit int) ([]postgres.DomainEvent, error)
+}
+
+// GetEventsHandle serves the domain event feed. Consumers start without after
+// and pass the returned next cursor on to the following request.
+func GetEventsHandle(getter EventsGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		after, err := postgres.ParseEventCursor(r.URL.Query().Get("after"))
+		if err != nil {
+			problem.Error(w, r, "Invalid after cursor", http.StatusBadRequest)
+			return
+		}
+
+		limit := defaultEventsLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			parsed, err := strconv.Atoi(v)
+			if err != nil || parsed <= 0 || parsed > maxEventsLimit {
+				problem.Error(w, r, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = parsed
+		}
+
+		events, err := getter.GetEvents(r.Context(), after, limit)
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+
+		next := after
+		if len(events) > 0 {
+			next = events[len(events)-1].Cursor()
+		}
+
+		writeJSON(w, r, http.StatusOK, struct {
+			Events []postgres.DomainEvent `json:"events"`
+			Next   string                 `json:"next"`
+		}{events, next.String()})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	response, err := json.Marshal(v)
+	if err != nil {
+		problem.Internal(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response)
+}
@@ -0,0 +1,320 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/handlers/orders"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/pagination"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"log/slog"
+	"math"
+	"net/http"
+)
+
+type BalanceAdjustmentData struct {
+	Amount float64 `json:"amount" validate:"required"`
+	Reason string  `json:"reason" validate:"required,max=500"`
+}
+
+type RoleData struct {
+	Role string `json:"role" validate:"required"`
+}
+
+type LoginUnlocker interface {
+	UnlockLogin(ctx context.Context, login, actor string) error
+}
+
+type Auditor interface {
+	AppendAudit(ctx context.Context, actor, action, target string, details any) error
+}
+
+type UserGetter interface {
+	Auditor
+	GetUserProfile(ctx context.Context, login string) (postgres.UserProfile, error)
+}
+
+type UserOrdersGetter interface {
+	UserGetter
+	GetOrders(ctx context.Context, login string, filter postgres.ListFilter) ([]postgres.Order, error)
+}
+
+type UserWithdrawalsGetter interface {
+	UserGetter
+	GetWithdrawals(ctx context.Context, login string, filter postgres.ListFilter) ([]postgres.Withdrawals, error)
+}
+
+type BalanceAdjuster interface {
+	AdjustBalance(ctx context.Context, login string, amount float64, reason, actor string) (postgres.Balance, error)
+}
+
+type RoleSetter interface {
+	SetUserRole(ctx context.Context, login, role, actor string) error
+}
+
+type UserDisabler interface {
+	DisableUser(ctx context.Context, login, actor string) error
+	EnableUser(ctx context.Context, login, actor string) error
+}
+
+// UnlockUserHandle lifts a login lockout before it expires and forgets the
+// failed attempts of the login.
+func UnlockUserHandle(unlocker LoginUnlocker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login := chi.URLParam(r, "login")
+
+		if err := unlocker.UnlockLogin(r.Context(), login, middleware.Actor(r.Context())); err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		slog.InfoContext(r.Context(), "login unlocked", "user", login)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetUserHandle returns the account of a user. Lookups are audited like
+// changes, since they expose customer data to staff.
+func GetUserHandle(users UserGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login := chi.URLParam(r, "login")
+
+		profile, err := users.GetUserProfile(r.Context(), login)
+		if err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		if !auditView(w, r, users, login, "profile") {
+			return
+		}
+
+		writeJSON(w, r, http.StatusOK, profile)
+	}
+}
+
+// GetUserOrdersHandle lists the orders of a user with the filters and
+// pagination of the user's own listing.
+func GetUserOrdersHandle(users UserOrdersGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login := chi.URLParam(r, "login")
+
+		filter, err := pagination.ParseFilter(r, orders.Statuses)
+		if err != nil {
+			problem.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if _, err := users.GetUserProfile(r.Context(), login); err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		limit := filter.Limit
+		if limit > 0 {
+			filter.Limit++
+		}
+
+		list, err := users.GetOrders(r.Context(), login, filter)
+		if err != nil && !errors.Is(err, storage.ErrNoOrders) {
+			problem.Internal(w, r, err)
+			return
+		}
+		if list == nil {
+			list = []postgres.Order{}
+		}
+
+		if !auditView(w, r, users, login, "orders") {
+			return
+		}
+
+		if limit > 0 && len(list) > limit {
+			list = list[:limit]
+			last := list[limit-1]
+			pagination.SetNextLink(w, r, postgres.Cursor{At: last.UploadedAt, ID: last.ID})
+		}
+
+		writeJSON(w, r, http.StatusOK, list)
+	}
+}
+
+// GetUserWithdrawalsHandle lists the withdrawals of a user with the filters
+// and pagination of the user's own listing.
+func GetUserWithdrawalsHandle(users UserWithdrawalsGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login := chi.URLParam(r, "login")
+
+		filter, err := pagination.ParseFilter(r, nil)
+		if err != nil {
+			problem.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if _, err := users.GetUserProfile(r.Context(), login); err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		limit := filter.Limit
+		if limit > 0 {
+			filter.Limit++
+		}
+
+		list, err := users.GetWithdrawals(r.Context(), login, filter)
+		if err != nil && !errors.Is(err, storage.ErrNoWithdrawalsFound) {
+			problem.Internal(w, r, err)
+			return
+		}
+		if list == nil {
+			list = []postgres.Withdrawals{}
+		}
+
+		if !auditView(w, r, users, login, "withdrawals") {
+			return
+		}
+
+		if limit > 0 && len(list) > limit {
+			list = list[:limit]
+			last := list[limit-1]
+			pagination.SetNextLink(w, r, postgres.Cursor{At: last.ProcessedAt, ID: last.ID})
+		}
+
+		writeJSON(w, r, http.StatusOK, list)
+	}
+}
+
+// AdjustBalanceHandle credits or debits a user's balance by hand. The reason
+// is mandatory and kept in the audit log. Staff cannot adjust their own
+// balance.
+func AdjustBalanceHandle(adjuster BalanceAdjuster) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login := chi.URLParam(r, "login")
+
+		if login == middleware.Actor(r.Context()) {
+			problem.Code(w, r, "Staff cannot adjust their own balance", "own_account", http.StatusForbidden)
+			return
+		}
+
+		var data BalanceAdjustmentData
+
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			problem.Error(w, r, "Error parsing request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := validator.New().Struct(data); err != nil || math.IsInf(data.Amount, 0) || math.IsNaN(data.Amount) {
+			problem.Error(w, r, "A non-zero amount and a reason are required", http.StatusBadRequest)
+			return
+		}
+
+		balance, err := adjuster.AdjustBalance(r.Context(), login, data.Amount, data.Reason, middleware.Actor(r.Context()))
+		if err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		slog.InfoContext(r.Context(), "balance adjusted", "user", login, "amount", data.Amount)
+
+		writeJSON(w, r, http.StatusOK, balance)
+	}
+}
+
+// SetUserRoleHandle changes the role of a user. Staff cannot change their
+// own role, so the last administrator cannot lock everyone out by mistake.
+func SetUserRoleHandle(setter RoleSetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login := chi.URLParam(r, "login")
+
+		var data RoleData
+
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			problem.Error(w, r, "Error parsing request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := validator.New().Struct(data); err != nil || !validRole(data.Role) {
+			problem.Code(w, r, "Role must be customer, support or admin", "invalid_role", http.StatusBadRequest)
+			return
+		}
+
+		if login == middleware.Actor(r.Context()) {
+			problem.Code(w, r, "Staff cannot change their own role", "own_account", http.StatusConflict)
+			return
+		}
+
+		if err := setter.SetUserRole(r.Context(), login, data.Role, middleware.Actor(r.Context())); err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		slog.InfoContext(r.Context(), "user role changed", "user", login, "to", data.Role)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DisableUserHandle stops a user from logging in and revokes their sessions
+// and API keys.
+func DisableUserHandle(disabler UserDisabler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login := chi.URLParam(r, "login")
+
+		if login == middleware.Actor(r.Context()) {
+			problem.Code(w, r, "Staff cannot disable their own account", "own_account", http.StatusConflict)
+			return
+		}
+
+		if err := disabler.DisableUser(r.Context(), login, middleware.Actor(r.Context())); err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		slog.InfoContext(r.Context(), "user disabled", "user", login)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// EnableUserHandle lets a disabled user log in again.
+func EnableUserHandle(disabler UserDisabler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login := chi.URLParam(r, "login")
+
+		if err := disabler.EnableUser(r.Context(), login, middleware.Actor(r.Context())); err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		slog.InfoContext(r.Context(), "user enabled", "user", login)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// auditView records that staff looked at data of a user and reports whether
+// the request may go on.
+func auditView(w http.ResponseWriter, r *http.Request, auditor Auditor, login, view string) bool {
+	err := auditor.AppendAudit(r.Context(), middleware.Actor(r.Context()), postgres.AuditUserViewed, "user:"+login, map[string]any{"view": view})
+	if err != nil {
+		problem.Internal(w, r, err)
+		return false
+	}
+
+	return true
+}
+
+func validRole(role string) bool {
+	for _, r := range postgres.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
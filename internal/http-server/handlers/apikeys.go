@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/apikey"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/auth"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/mfa"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/clientip"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/ratelimit"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+type APIKeyData struct {
+	Name      string   `json:"name" validate:"required,max=100"`
+	Scopes    []string `json:"scopes" validate:"required"`
+	RateLimit string   `json:"rate_limit"`
+}
+
+// CreatedAPIKey is returned once, when the key is created.
+type CreatedAPIKey struct {
+	postgres.APIKey
+	Key string `json:"key"`
+}
+
+type APIKeyCreator interface {
+	mfa.GuardedStore
+	CreateAPIKey(ctx context.Context, login, name, display, hash string, scopes []string, rateLimit string) (postgres.APIKey, error)
+}
+
+type APIKeyGetter interface {
+	GetAPIKeys(ctx context.Context, login string) ([]postgres.APIKey, error)
+}
+
+type APIKeyRevoker interface {
+	RevokeAPIKey(ctx context.Context, login string, id int64) error
+}
+
+// CreateAPIKeyHandle issues a key with the requested scopes and optionally a
+// rate limit stricter than maxLimit. Users with 2FA confirm it with a code in
+// X-MFA-Code, since the key outlives any session.
+func CreateAPIKeyHandle(creator APIKeyCreator, maxLimit ratelimit.Policy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login := auth.GetUserID(r.Header.Get("Authorization"))
+		if login == "" {
+			problem.Error(w, r, "User not authorized", http.StatusUnauthorized)
+			return
+		}
+
+		var data APIKeyData
+
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			problem.Error(w, r, "Error parsing request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := validator.New().Struct(data); err != nil {
+			problem.Error(w, r, "Name and scopes are required", http.StatusBadRequest)
+			return
+		}
+
+		if err := apikey.ValidateScopes(data.Scopes); err != nil {
+			problem.Code(w, r, err.Error(), "invalid_scope", http.StatusBadRequest)
+			return
+		}
+
+		if data.RateLimit != "" {
+			policy, err := ratelimit.ParsePolicy(data.RateLimit)
+			if err != nil {
+				problem.Code(w, r, "Invalid rate limit: "+err.Error(), "invalid_rate_limit", http.StatusBadRequest)
+				return
+			}
+			if !policy.Within(maxLimit) {
+				problem.Code(w, r, "Rate limit may not exceed the default API key limit", "invalid_rate_limit", http.StatusBadRequest)
+				return
+			}
+		}
+
+		enabled, err := mfa.Enabled(r.Context(), creator, login)
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+		if enabled {
+			code := r.Header.Get(MFACodeHeader)
+			if code == "" {
+				problem.FromError(w, r, storage.ErrMFARequired)
+				return
+			}
+
+			if err := mfa.Confirm(r.Context(), creator, login, code, clientip.Get(r.Context())); err != nil {
+				problem.FromError(w, r, err)
+				return
+			}
+		}
+
+		key, display, hash, err := apikey.Generate()
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+
+		created, err := creator.CreateAPIKey(r.Context(), login, data.Name, display, hash, data.Scopes, data.RateLimit)
+		if err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		response, err := json.Marshal(CreatedAPIKey{APIKey: created, Key: key})
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+
+		slog.InfoContext(r.Context(), "api key created", "user", login, "api_key", created.ID)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(response)
+	}
+}
+
+func GetAPIKeysHandle(getter APIKeyGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login := auth.GetUserID(r.Header.Get("Authorization"))
+		if login == "" {
+			problem.Error(w, r, "User not authorized", http.StatusUnauthorized)
+			return
+		}
+
+		keys, err := getter.GetAPIKeys(r.Context(), login)
+		if err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		response, err := json.Marshal(keys)
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(response)
+	}
+}
+
+func RevokeAPIKeyHandle(revoker APIKeyRevoker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login := auth.GetUserID(r.Header.Get("Authorization"))
+		if login == "" {
+			problem.Error(w, r, "User not authorized", http.StatusUnauthorized)
+			return
+		}
+
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			problem.Error(w, r, "Invalid API key ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := revoker.RevokeAPIKey(r.Context(), login, id); err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		slog.InfoContext(r.Context(), "api key revoked", "user", login, "api_key", id)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
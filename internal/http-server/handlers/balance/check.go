@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/auth"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
 	"net/http"
 )
@@ -16,21 +17,21 @@ func CheckBalanceHandle(balanceGetter UserBalanceGetter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			http.Error(w, "User not authorized", http.StatusUnauthorized)
+			problem.Error(w, r, "User not authorized", http.StatusUnauthorized)
 			return
 		}
 
-		login := auth.GetUserID(authHeader)
+		login := auth.RequestLogin(r)
 
 		balance, err := balanceGetter.GetBalance(r.Context(), login)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problem.Internal(w, r, err)
 			return
 		}
 
 		balanceJSON, err := json.Marshal(balance)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problem.Internal(w, r, err)
 			return
 		}
 
@@ -5,77 +5,105 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/auth"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/mfa"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/clientip"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/pagination"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/validation"
 	"io"
+	"log/slog"
 	"net/http"
 	"strconv"
 )
 
 type WithdrawalRequest struct {
-	Order string  `json:"order"`
-	Sum   float64 `json:"sum"`
+	Order    string  `json:"order"`
+	Sum      float64 `json:"sum"`
+	Merchant string  `json:"merchant,omitempty"`
 }
 
 type UserBalanceWithdraw interface {
-	RequestWithdraw(ctx context.Context, login string, amount float64, orderID string) error
-	LoadOrder(ctx context.Context, login, orderID string) error
-	GetWithdrawals(ctx context.Context, login string) ([]postgres.Withdrawals, error)
+	RequestWithdraw(ctx context.Context, login string, amount float64, orderID string, merchantID int64) error
+	LoadOrder(ctx context.Context, login, orderID string, merchantID int64) error
+	GetWithdrawals(ctx context.Context, login string, filter postgres.ListFilter) ([]postgres.Withdrawals, error)
+	GetMerchantByName(ctx context.Context, name string) (postgres.Merchant, error)
 }
 
-func RequestWithdrawHandle(withdraw UserBalanceWithdraw) http.HandlerFunc {
+// RequestWithdrawHandle spends points. Withdrawals above mfaThreshold need a
+// second factor in the X-MFA-Code header; a zero threshold turns that off.
+func RequestWithdrawHandle(withdraw UserBalanceWithdraw, confirmer mfa.GuardedStore, mfaThreshold float64) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			http.Error(w, "User not authorized", http.StatusUnauthorized)
+			problem.Error(w, r, "User not authorized", http.StatusUnauthorized)
 			return
 		}
 
-		login := auth.GetUserID(authHeader)
+		login := auth.RequestLogin(r)
 
 		var withdrawalReq WithdrawalRequest
 
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, "Error reading request body", http.StatusInternalServerError)
+			problem.Error(w, r, "Error reading request body", http.StatusBadRequest)
 			return
 		}
 		err = json.Unmarshal(body, &withdrawalReq)
 		if err != nil {
-			http.Error(w, "Error parsing request body", http.StatusBadRequest)
+			problem.Error(w, r, "Error parsing request body", http.StatusBadRequest)
 			return
 		}
 
 		intOrderID, _ := strconv.Atoi(withdrawalReq.Order)
 		if !validation.Valid(intOrderID) {
-			http.Error(w, "Invalid order ID", http.StatusUnprocessableEntity)
+			problem.Code(w, r, "Invalid order ID", "invalid_order_number", http.StatusUnprocessableEntity)
 			return
 		}
 
-		err = withdraw.RequestWithdraw(r.Context(), login, withdrawalReq.Sum, withdrawalReq.Order)
-		if err != nil {
-			if errors.Is(err, storage.ErrNotEnoughBalance) {
-				http.Error(w, "Not enough balance", http.StatusPaymentRequired)
+		if !validation.ValidSum(withdrawalReq.Sum) {
+			problem.FromError(w, r, storage.ErrInvalidSum)
+			return
+		}
+
+		if mfaThreshold > 0 && withdrawalReq.Sum > mfaThreshold {
+			if err := confirmWithdrawal(r, confirmer, login); err != nil {
+				slog.InfoContext(r.Context(), "withdrawal not confirmed", "user", login, "error", err)
+				problem.FromError(w, r, err)
+				return
+			}
+		}
+
+		var merchantID int64
+		if withdrawalReq.Merchant != "" {
+			merchant, err := withdraw.GetMerchantByName(r.Context(), withdrawalReq.Merchant)
+			if err != nil {
+				if errors.Is(err, storage.ErrMerchantNotFound) {
+					problem.Code(w, r, "Unknown merchant", "unknown_merchant", http.StatusBadRequest)
+					return
+				}
+
+				problem.Internal(w, r, err)
 				return
 			}
+			merchantID = merchant.ID
+		}
 
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		err = withdraw.RequestWithdraw(r.Context(), login, withdrawalReq.Sum, withdrawalReq.Order, merchantID)
+		if err != nil {
+			problem.FromError(w, r, err)
 			return
 		}
 
-		err = withdraw.LoadOrder(r.Context(), login, withdrawalReq.Order)
+		err = withdraw.LoadOrder(r.Context(), login, withdrawalReq.Order, merchantID)
 		if err != nil {
 			if errors.Is(err, storage.ErrOrderAlreadyLoadedByUser) {
-				http.Error(w, "Order already loaded", http.StatusOK)
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("Order already loaded"))
 				return
 			}
-			if errors.Is(err, storage.ErrOrderAlreadyLoadedByAnotherUser) {
-				http.Error(w, "Order already loaded by another user", http.StatusConflict)
-				return
-			}
-
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problem.FromError(w, r, err)
 			return
 		}
 
@@ -84,30 +112,63 @@ func RequestWithdrawHandle(withdraw UserBalanceWithdraw) http.HandlerFunc {
 	}
 }
 
+// confirmWithdrawal checks the second factor of a large withdrawal. Users
+// without 2FA have to enable it first.
+func confirmWithdrawal(r *http.Request, confirmer mfa.GuardedStore, login string) error {
+	enabled, err := mfa.Enabled(r.Context(), confirmer, login)
+	if err != nil {
+		return err
+	}
+
+	code := r.Header.Get("X-MFA-Code")
+	if !enabled || code == "" {
+		return storage.ErrMFARequired
+	}
+
+	return mfa.Confirm(r.Context(), confirmer, login, code, clientip.Get(r.Context()))
+}
+
 func GetWithdrawalsHandle(withdraw UserBalanceWithdraw) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			http.Error(w, "User not authorized", http.StatusUnauthorized)
+			problem.Error(w, r, "User not authorized", http.StatusUnauthorized)
+			return
+		}
+
+		login := auth.RequestLogin(r)
+
+		filter, err := pagination.ParseFilter(r, nil)
+		if err != nil {
+			problem.Error(w, r, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		login := auth.GetUserID(authHeader)
+		limit := filter.Limit
+		if limit > 0 {
+			filter.Limit++
+		}
 
-		withdrawals, err := withdraw.GetWithdrawals(r.Context(), login)
+		withdrawals, err := withdraw.GetWithdrawals(r.Context(), login, filter)
 		if err != nil {
 			if errors.Is(err, storage.ErrNoWithdrawalsFound) {
-				http.Error(w, "No withdrawals found", http.StatusNoContent)
+				problem.Error(w, r, "No withdrawals found", http.StatusNoContent)
 				return
 			}
 
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problem.Internal(w, r, err)
 			return
 		}
 
+		if limit > 0 && len(withdrawals) > limit {
+			withdrawals = withdrawals[:limit]
+			last := withdrawals[limit-1]
+			pagination.SetNextLink(w, r, postgres.Cursor{At: last.ProcessedAt, ID: last.ID})
+		}
+
 		withdrawalsJSON, err := json.Marshal(withdrawals)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problem.Internal(w, r, err)
 			return
 		}
 
@@ -0,0 +1,57 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/health"
+	"net/http"
+	"time"
+)
+
+const checkTimeout = 5 * time.Second
+
+type ReadinessChecker interface {
+	Check(ctx context.Context) health.Report
+}
+
+// LiveHandle reports that the process is up, answering 503 once a background
+// worker has exited. It checks nothing else, so a slow dependency never gets
+// the service restarted.
+func LiveHandle() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := health.Live()
+
+		status := http.StatusOK
+		if report.Status != health.StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+
+		writeJSON(w, status, report)
+	}
+}
+
+// ReadyHandle reports whether the service can take traffic, answering 503
+// with the failed checks when it cannot.
+func ReadyHandle(checker ReadinessChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		defer cancel()
+
+		report := checker.Check(ctx)
+
+		status := http.StatusOK
+		if report.Status != health.StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+
+		writeJSON(w, status, report)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(v)
+}
@@ -6,9 +6,13 @@ import (
 	"errors"
 	"github.com/go-playground/validator/v10"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/auth"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/mfa"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/clientip"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
 	"log/slog"
 	"net/http"
+	"time"
 )
 
 type LoginData struct {
@@ -16,62 +20,164 @@ type LoginData struct {
 	Password string `json:"password" validate:"required"`
 }
 
+// MFAChallenge is returned instead of a session when the user has 2FA
+// enabled. The token is exchanged for a session with a code.
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
 type UserGetter interface {
 	GetUser(ctx context.Context, login, password string) (string, error)
 }
 
-func LoginHandle(userGetter UserGetter) http.HandlerFunc {
+// LoginGuard tracks failed logins per login and per client IP.
+type LoginGuard interface {
+	LoginLockedUntil(ctx context.Context, login, ip string) (time.Time, error)
+	RecordLoginFailure(ctx context.Context, login, ip string) (time.Time, error)
+	RecordLoginSuccess(ctx context.Context, login string) error
+}
+
+type SessionVersionGetter interface {
+	GetSessionVersion(ctx context.Context, login string) (int, error)
+}
+
+type Authenticator interface {
+	UserGetter
+	LoginGuard
+	SessionVersionGetter
+	mfa.Getter
+}
+
+// LoginCompleter finishes logins checked by any first factor.
+type LoginCompleter interface {
+	SessionVersionGetter
+	mfa.Getter
+	RecordLoginSuccess(ctx context.Context, login string) error
+}
+
+// Authenticate checks the credentials of a login attempt from ip. Locked out
+// attempts are refused without looking at the password, and unknown logins
+// fail just like wrong passwords, so the answer does not tell whether a user
+// exists. Failures are not reset here: a correct password alone does not
+// complete a login with a second factor.
+func Authenticate(ctx context.Context, a Authenticator, login, password, ip string) error {
+	until, err := a.LoginLockedUntil(ctx, login, ip)
+	if err != nil {
+		return err
+	}
+	if !until.IsZero() {
+		return &storage.LockedError{Until: until}
+	}
+
+	_, err = a.GetUser(ctx, login, password)
+	if errors.Is(err, storage.ErrUserNotFound) || errors.Is(err, storage.ErrIncorrectPassword) {
+		until, recordErr := a.RecordLoginFailure(ctx, login, ip)
+		if recordErr != nil {
+			return recordErr
+		}
+		if !until.IsZero() {
+			slog.WarnContext(ctx, "login locked after failed attempts", "user", login, "ip", ip, "until", until)
+		}
+
+		return storage.ErrIncorrectPassword
+	}
+
+	return err
+}
+
+func LoginHandle(authenticator Authenticator) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "Method is not allowed", http.StatusMethodNotAllowed)
+			problem.Error(w, r, "Method is not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
 		var data LoginData
 
 		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-			slog.Info("invalid login request", err)
+			slog.InfoContext(r.Context(), "invalid login request", "error", err)
 
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			problem.Error(w, r, "Error parsing request body", http.StatusBadRequest)
 			return
 		}
 
 		if err := validator.New().Struct(data); err != nil {
-			slog.Error("invalid validation for login", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			slog.InfoContext(r.Context(), "invalid validation for login", "error", err)
+			problem.Error(w, r, "Login and password are required", http.StatusBadRequest)
 			return
 		}
 
-		_, err := userGetter.GetUser(r.Context(), data.Login, data.Password)
-		if errors.Is(err, storage.ErrIncorrectPassword) {
-			slog.Info("password is incorrect")
+		err := Authenticate(r.Context(), authenticator, data.Login, data.Password, clientip.Get(r.Context()))
+		if err != nil {
+			slog.InfoContext(r.Context(), "login failed", "user", data.Login, "error", err)
 
-			http.Error(w, "password is incorrect", http.StatusUnauthorized)
+			problem.FromError(w, r, err)
 			return
 		}
-		if err != nil {
-			if errors.Is(err, storage.ErrUserNotFound) {
-				slog.Info("user not found")
 
-				http.Error(w, "user not found", http.StatusUnauthorized)
-				return
-			}
-			slog.Info("failed to get user while login", err)
+		completeLogin(w, r, authenticator, data.Login)
+	}
+}
 
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+// completeLogin answers a login whose first factor was checked: with a
+// challenge when the user has 2FA enabled, otherwise with a session.
+func completeLogin(w http.ResponseWriter, r *http.Request, completer LoginCompleter, login string) {
+	enabled, err := mfa.Enabled(r.Context(), completer, login)
+	if err != nil {
+		problem.Internal(w, r, err)
+		return
+	}
+	if enabled {
+		mfaToken, err := auth.BuildMFAToken(login)
+		if err != nil {
+			problem.Internal(w, r, err)
 			return
 		}
 
-		tokenString, err := auth.BuildJWTString(data.Login)
+		response, err := json.Marshal(MFAChallenge{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			ExpiresIn:   int(auth.MFATokenExp.Seconds()),
+		})
 		if err != nil {
-			slog.Info("failed to create JWT token", err)
-			http.Error(w, "failed to create JWT token", http.StatusInternalServerError)
+			problem.Internal(w, r, err)
 			return
 		}
 
-		w.Header().Set("Authorization", tokenString)
-		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(response)
+		return
+	}
 
-		w.Write([]byte(data.Login))
+	if err := completer.RecordLoginSuccess(r.Context(), login); err != nil {
+		problem.Internal(w, r, err)
+		return
 	}
+
+	issueToken(w, r, completer, login)
+}
+
+// issueToken completes a login, returning a session token in the
+// Authorization header.
+func issueToken(w http.ResponseWriter, r *http.Request, sessions SessionVersionGetter, login string) {
+	version, err := sessions.GetSessionVersion(r.Context(), login)
+	if err != nil {
+		problem.FromError(w, r, err)
+		return
+	}
+
+	tokenString, err := auth.BuildJWTString(login, version)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to create JWT token", "user", login, "error", err)
+		problem.Internal(w, r, err)
+		return
+	}
+
+	w.Header().Set("Authorization", tokenString)
+	w.WriteHeader(http.StatusOK)
+
+	w.Write([]byte(login))
 }
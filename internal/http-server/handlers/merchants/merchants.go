@@ -0,0 +1,222 @@
+package merchants
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type MerchantData struct {
+	Name          string  `json:"name" validate:"required"`
+	AccrualURL    string  `json:"accrual_url" validate:"required,url"`
+	RateLimit     float64 `json:"rate_limit" validate:"gte=0"`
+	Burst         int     `json:"burst" validate:"gte=0"`
+	Credentials   string  `json:"credentials"`
+	WebhookSecret string  `json:"webhook_secret" validate:"required_with=WebhookURL"`
+	WebhookURL    string  `json:"webhook_url" validate:"omitempty,url"`
+}
+
+// MerchantUpdateData is the body of a merchant update. Omitted credentials
+// or webhook_secret keep the stored values; an empty string clears them.
+type MerchantUpdateData struct {
+	Name          string  `json:"name" validate:"required"`
+	AccrualURL    string  `json:"accrual_url" validate:"required,url"`
+	RateLimit     float64 `json:"rate_limit" validate:"gte=0"`
+	Burst         int     `json:"burst" validate:"gte=0"`
+	Credentials   *string `json:"credentials"`
+	WebhookSecret *string `json:"webhook_secret"`
+	WebhookURL    string  `json:"webhook_url" validate:"omitempty,url"`
+}
+
+type MerchantManager interface {
+	SaveMerchant(ctx context.Context, m postgres.Merchant) (int64, error)
+	GetMerchant(ctx context.Context, id int64) (postgres.Merchant, error)
+	GetMerchants(ctx context.Context) ([]postgres.Merchant, error)
+	UpdateMerchant(ctx context.Context, u postgres.MerchantUpdate) (postgres.Merchant, error)
+	DeleteMerchant(ctx context.Context, id int64) error
+}
+
+func (d MerchantData) merchant() postgres.Merchant {
+	burst := d.Burst
+	if burst == 0 {
+		burst = 1
+	}
+
+	return postgres.Merchant{
+		Name:          d.Name,
+		AccrualURL:    d.AccrualURL,
+		RateLimit:     d.RateLimit,
+		Burst:         burst,
+		Credentials:   d.Credentials,
+		WebhookSecret: d.WebhookSecret,
+		WebhookURL:    d.WebhookURL,
+	}
+}
+
+func (d MerchantUpdateData) update(id int64) postgres.MerchantUpdate {
+	burst := d.Burst
+	if burst == 0 {
+		burst = 1
+	}
+
+	return postgres.MerchantUpdate{
+		ID:            id,
+		Name:          d.Name,
+		AccrualURL:    d.AccrualURL,
+		RateLimit:     d.RateLimit,
+		Burst:         burst,
+		Credentials:   d.Credentials,
+		WebhookSecret: d.WebhookSecret,
+		WebhookURL:    d.WebhookURL,
+	}
+}
+
+func CreateMerchantHandle(manager MerchantManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var data MerchantData
+
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			problem.Error(w, r, "Error parsing request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := validator.New().Struct(data); err != nil {
+			problem.Error(w, r, "Invalid merchant data", http.StatusBadRequest)
+			return
+		}
+
+		m := data.merchant()
+
+		id, err := manager.SaveMerchant(r.Context(), m)
+		if err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+		m.ID = id
+
+		writeJSON(w, r, http.StatusCreated, m)
+	}
+}
+
+func GetMerchantsHandle(manager MerchantManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		merchants, err := manager.GetMerchants(r.Context())
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+
+		writeJSON(w, r, http.StatusOK, merchants)
+	}
+}
+
+func GetMerchantHandle(manager MerchantManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			problem.Error(w, r, "Invalid merchant ID", http.StatusBadRequest)
+			return
+		}
+
+		m, err := manager.GetMerchant(r.Context(), id)
+		if err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		writeJSON(w, r, http.StatusOK, m)
+	}
+}
+
+func UpdateMerchantHandle(manager MerchantManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			problem.Error(w, r, "Invalid merchant ID", http.StatusBadRequest)
+			return
+		}
+
+		var data MerchantUpdateData
+
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			problem.Error(w, r, "Error parsing request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := validator.New().Struct(data); err != nil {
+			problem.Error(w, r, "Invalid merchant data", http.StatusBadRequest)
+			return
+		}
+
+		m, err := manager.UpdateMerchant(r.Context(), data.update(id))
+		if err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		writeJSON(w, r, http.StatusOK, m)
+	}
+}
+
+func DeleteMerchantHandle(manager MerchantManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			problem.Error(w, r, "Invalid merchant ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := manager.DeleteMerchant(r.Context(), id); err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	response, err := json.Marshal(v)
+	if err != nil {
+		problem.Internal(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response)
+}
+
+type LatencyGetter interface {
+	GetAccrualLatency(ctx context.Context, since time.Time) ([]postgres.AccrualLatency, error)
+}
+
+// GetAccrualLatencyHandle reports accrual latency per merchant for orders
+// uploaded after since (RFC3339), by default during the last 30 days.
+func GetAccrualLatencyHandle(getter LatencyGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		since := time.Now().Add(-30 * 24 * time.Hour)
+		if v := r.URL.Query().Get("since"); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				problem.Error(w, r, "since must be an RFC3339 date", http.StatusBadRequest)
+				return
+			}
+			since = parsed
+		}
+
+		stats, err := getter.GetAccrualLatency(r.Context(), since)
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+
+		writeJSON(w, r, http.StatusOK, stats)
+	}
+}
@@ -0,0 +1,44 @@
+package merchants
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"net/http"
+	"strconv"
+)
+
+type WebhookReplayer interface {
+	GetDeadWebhooks(ctx context.Context) ([]postgres.WebhookDelivery, error)
+	ReplayWebhook(ctx context.Context, id int64) error
+}
+
+func GetDeadWebhooksHandle(replayer WebhookReplayer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deliveries, err := replayer.GetDeadWebhooks(r.Context())
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+
+		writeJSON(w, r, http.StatusOK, deliveries)
+	}
+}
+
+func ReplayWebhookHandle(replayer WebhookReplayer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			problem.Error(w, r, "Invalid webhook ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := replayer.ReplayWebhook(r.Context(), id); err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
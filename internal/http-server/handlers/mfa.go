@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-playground/validator/v10"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/auth"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/mfa"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/clientip"
+	"log/slog"
+	"net/http"
+)
+
+// MFACodeHeader carries a TOTP or recovery code for actions that need one.
+const MFACodeHeader = "X-MFA-Code"
+
+type MFACodeData struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type MFALoginData struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
+
+type MFADisabler interface {
+	mfa.GuardedStore
+	DisableMFA(ctx context.Context, login string) error
+}
+
+type MFALoginVerifier interface {
+	mfa.GuardedStore
+	SessionVersionGetter
+	RecordLoginSuccess(ctx context.Context, login string) error
+}
+
+// EnrollMFAHandle starts a TOTP enrollment and returns the otpauth URI and
+// recovery codes. 2FA is enabled once a code is confirmed.
+func EnrollMFAHandle(enroller mfa.Enroller) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login := auth.GetUserID(r.Header.Get("Authorization"))
+		if login == "" {
+			problem.Error(w, r, "User not authorized", http.StatusUnauthorized)
+			return
+		}
+
+		enrollment, err := mfa.Enroll(r.Context(), enroller, login)
+		if err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		response, err := json.Marshal(enrollment)
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		w.Write(response)
+	}
+}
+
+// EnableMFAHandle confirms the enrollment with a code from the app.
+func EnableMFAHandle(enroller mfa.Enroller) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login := auth.GetUserID(r.Header.Get("Authorization"))
+		if login == "" {
+			problem.Error(w, r, "User not authorized", http.StatusUnauthorized)
+			return
+		}
+
+		var data MFACodeData
+
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			problem.Error(w, r, "Error parsing request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := validator.New().Struct(data); err != nil {
+			problem.Error(w, r, "Code is required", http.StatusBadRequest)
+			return
+		}
+
+		if err := mfa.Enable(r.Context(), enroller, login, data.Code); err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		slog.InfoContext(r.Context(), "two-factor authentication enabled", "user", login)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DisableMFAHandle turns 2FA off after checking a code.
+func DisableMFAHandle(disabler MFADisabler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login := auth.GetUserID(r.Header.Get("Authorization"))
+		if login == "" {
+			problem.Error(w, r, "User not authorized", http.StatusUnauthorized)
+			return
+		}
+
+		code := r.Header.Get(MFACodeHeader)
+		if code == "" {
+			problem.Error(w, r, "Code is required in "+MFACodeHeader, http.StatusBadRequest)
+			return
+		}
+
+		if err := mfa.Confirm(r.Context(), disabler, login, code, clientip.Get(r.Context())); err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		if err := disabler.DisableMFA(r.Context(), login); err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		slog.InfoContext(r.Context(), "two-factor authentication disabled", "user", login)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// VerifyLoginHandle exchanges the token LoginHandle returned for a user with
+// 2FA, together with a code, for a session.
+func VerifyLoginHandle(verifier MFALoginVerifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var data MFALoginData
+
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			problem.Error(w, r, "Error parsing request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := validator.New().Struct(data); err != nil {
+			problem.Error(w, r, "MFA token and code are required", http.StatusBadRequest)
+			return
+		}
+
+		login := auth.GetMFALogin(data.MFAToken)
+		if login == "" {
+			problem.Code(w, r, "MFA token is invalid or expired, log in again", "invalid_mfa_token", http.StatusUnauthorized)
+			return
+		}
+
+		if err := mfa.Confirm(r.Context(), verifier, login, data.Code, clientip.Get(r.Context())); err != nil {
+			slog.InfoContext(r.Context(), "second factor failed", "user", login, "error", err)
+			problem.FromError(w, r, err)
+			return
+		}
+
+		if err := verifier.RecordLoginSuccess(r.Context(), login); err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+
+		issueToken(w, r, verifier, login)
+	}
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/auth"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/oidc"
+	"log/slog"
+	"net/http"
+)
+
+// OIDCCookie keeps the signed state of an OpenID Connect login between the
+// redirect to the provider and the callback.
+const OIDCCookie = "gophermart_oidc"
+
+const oidcCookiePath = "/api/user/oidc"
+
+type OIDCProvider interface {
+	Issuer() string
+	AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error)
+	Exchange(ctx context.Context, code, verifier, nonce string) (oidc.Identity, error)
+}
+
+type OIDCLinker interface {
+	LinkOIDCUser(ctx context.Context, issuer, subject, login string, linkExisting bool) (string, error)
+}
+
+type OIDCUserStore interface {
+	OIDCLinker
+	LoginCompleter
+}
+
+// OIDCLoginHandle redirects the user to the provider, starting the
+// authorization code flow with PKCE. The provider is nil when OpenID Connect
+// is not configured.
+func OIDCLoginHandle(provider OIDCProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if provider == nil {
+			problem.Code(w, r, "OpenID Connect login is not configured", "oidc_disabled", http.StatusNotFound)
+			return
+		}
+
+		var flow auth.OIDCFlow
+		for _, v := range []*string{&flow.State, &flow.Nonce, &flow.Verifier} {
+			s, err := oidc.RandomString()
+			if err != nil {
+				problem.Internal(w, r, err)
+				return
+			}
+			*v = s
+		}
+
+		target, err := provider.AuthCodeURL(r.Context(), flow.State, flow.Nonce, flow.Verifier)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "identity provider is unavailable", "error", err)
+			problem.Error(w, r, "Identity provider is unavailable", http.StatusBadGateway)
+			return
+		}
+
+		token, err := auth.BuildOIDCFlowToken(flow)
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     OIDCCookie,
+			Value:    token,
+			Path:     oidcCookiePath,
+			MaxAge:   int(auth.OIDCFlowExp.Seconds()),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, target, http.StatusFound)
+	}
+}
+
+// OIDCCallbackHandle completes a login at the provider. The user of the
+// identity is created on the first login and then logged in like with a
+// password, including the second factor if enabled.
+func OIDCCallbackHandle(provider OIDCProvider, users OIDCUserStore, linkExisting bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if provider == nil {
+			problem.Code(w, r, "OpenID Connect login is not configured", "oidc_disabled", http.StatusNotFound)
+			return
+		}
+
+		var flow *auth.OIDCFlow
+		if cookie, err := r.Cookie(OIDCCookie); err == nil {
+			flow = auth.GetOIDCFlow(cookie.Value)
+		}
+		http.SetCookie(w, &http.Cookie{Name: OIDCCookie, Path: oidcCookiePath, MaxAge: -1})
+
+		query := r.URL.Query()
+		if flow == nil || subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(flow.State)) != 1 {
+			problem.Code(w, r, "Login state is missing or does not match, start the login again", "invalid_oidc_state", http.StatusBadRequest)
+			return
+		}
+
+		if reason := query.Get("error"); reason != "" {
+			slog.InfoContext(r.Context(), "identity provider refused login", "error", reason, "description", query.Get("error_description"))
+			problem.Code(w, r, "Identity provider refused the login", "oidc_denied", http.StatusUnauthorized)
+			return
+		}
+
+		code := query.Get("code")
+		if code == "" {
+			problem.Error(w, r, "Authorization code is required", http.StatusBadRequest)
+			return
+		}
+
+		identity, err := provider.Exchange(r.Context(), code, flow.Verifier, flow.Nonce)
+		if errors.Is(err, oidc.ErrInvalidIDToken) {
+			slog.WarnContext(r.Context(), "invalid id token", "error", err)
+			problem.Code(w, r, "Identity provider returned an invalid ID token", "invalid_id_token", http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, oidc.ErrCodeRejected) {
+			slog.InfoContext(r.Context(), "authorization code rejected", "error", err)
+			problem.Code(w, r, "Authorization code is invalid or expired, start the login again", "invalid_authorization_code", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to exchange authorization code", "error", err)
+			problem.Error(w, r, "Identity provider is unavailable", http.StatusBadGateway)
+			return
+		}
+
+		login, err := users.LinkOIDCUser(r.Context(), provider.Issuer(), identity.Subject, identity.Login(), linkExisting)
+		if err != nil {
+			slog.InfoContext(r.Context(), "failed to link identity", "subject", identity.Subject, "error", err)
+			problem.FromError(w, r, err)
+			return
+		}
+
+		completeLogin(w, r, users, login)
+	}
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/oidc"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/oidc/oidctest"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+const testOIDCClientID = "gophermart"
+
+type oidcUsers struct {
+	issuer, subject, login string
+	linkExisting           bool
+}
+
+func (u *oidcUsers) LinkOIDCUser(_ context.Context, issuer, subject, login string, linkExisting bool) (string, error) {
+	u.issuer, u.subject, u.login, u.linkExisting = issuer, subject, login, linkExisting
+
+	return login, nil
+}
+
+func (u *oidcUsers) GetSessionVersion(context.Context, string) (int, error) {
+	return 1, nil
+}
+
+func (u *oidcUsers) GetMFA(context.Context, string) (postgres.MFA, error) {
+	return postgres.MFA{}, storage.ErrMFANotEnrolled
+}
+
+func (u *oidcUsers) RecordLoginSuccess(context.Context, string) error {
+	return nil
+}
+
+// startOIDCLogin runs the login redirect and the provider's authorization,
+// returning the flow cookie and the query the provider calls back with.
+func startOIDCLogin(t *testing.T, provider OIDCProvider) (*http.Cookie, url.Values) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	OIDCLoginHandle(provider)(w, httptest.NewRequest(http.MethodGet, "/api/user/oidc/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login status = %d, want %d", w.Code, http.StatusFound)
+	}
+
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == OIDCCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatal("login did not set the flow cookie")
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorization failed: %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	return cookie, callback.Query()
+}
+
+func callOIDCCallback(provider OIDCProvider, users OIDCUserStore, cookie *http.Cookie, query url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/api/user/oidc/callback?"+query.Encode(), nil)
+	r.Header.Set("Accept", "application/json")
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+
+	w := httptest.NewRecorder()
+	OIDCCallbackHandle(provider, users, false)(w, r)
+
+	return w
+}
+
+func problemCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var p struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatalf("failed to decode problem %q: %v", w.Body.String(), err)
+	}
+
+	return p.Code
+}
+
+func TestOIDCCallback(t *testing.T) {
+	idp := oidctest.NewProvider(testOIDCClientID)
+	defer idp.Close()
+
+	provider := oidc.New(idp.Issuer(), testOIDCClientID, "", "http://gophermart.test/api/user/oidc/callback")
+
+	t.Run("first login", func(t *testing.T) {
+		idp.SetUser(oidctest.User{Subject: "sub-1", PreferredUsername: "alice"})
+		users := &oidcUsers{}
+
+		cookie, query := startOIDCLogin(t, provider)
+		w := callOIDCCallback(provider, users, cookie, query)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+		if w.Header().Get("Authorization") == "" {
+			t.Error("no session token issued")
+		}
+		if users.issuer != idp.Issuer() || users.subject != "sub-1" || users.login != "alice" {
+			t.Errorf("linked %+v, want issuer %q, subject sub-1, login alice", users, idp.Issuer())
+		}
+	})
+
+	t.Run("state mismatch", func(t *testing.T) {
+		idp.SetUser(oidctest.User{Subject: "sub-1"})
+		users := &oidcUsers{}
+
+		cookie, query := startOIDCLogin(t, provider)
+		query.Set("state", "forged")
+		w := callOIDCCallback(provider, users, cookie, query)
+
+		if w.Code != http.StatusBadRequest || problemCode(t, w) != "invalid_oidc_state" {
+			t.Fatalf("status = %d, body %s, want 400 invalid_oidc_state", w.Code, w.Body.String())
+		}
+		if users.subject != "" {
+			t.Error("identity linked despite the state mismatch")
+		}
+	})
+
+	t.Run("missing flow cookie", func(t *testing.T) {
+		idp.SetUser(oidctest.User{Subject: "sub-1"})
+
+		_, query := startOIDCLogin(t, provider)
+		w := callOIDCCallback(provider, &oidcUsers{}, nil, query)
+
+		if w.Code != http.StatusBadRequest || problemCode(t, w) != "invalid_oidc_state" {
+			t.Fatalf("status = %d, body %s, want 400 invalid_oidc_state", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		idp.SetUser(oidctest.User{Subject: "sub-1", Nonce: "replayed"})
+		users := &oidcUsers{}
+
+		cookie, query := startOIDCLogin(t, provider)
+		w := callOIDCCallback(provider, users, cookie, query)
+
+		if w.Code != http.StatusUnauthorized || problemCode(t, w) != "invalid_id_token" {
+			t.Fatalf("status = %d, body %s, want 401 invalid_id_token", w.Code, w.Body.String())
+		}
+		if users.subject != "" {
+			t.Error("identity linked despite the nonce mismatch")
+		}
+	})
+
+	t.Run("provider refused", func(t *testing.T) {
+		idp.SetUser(oidctest.User{Subject: "sub-1"})
+
+		cookie, query := startOIDCLogin(t, provider)
+		query.Del("code")
+		query.Set("error", "access_denied")
+		w := callOIDCCallback(provider, &oidcUsers{}, cookie, query)
+
+		if w.Code != http.StatusUnauthorized || problemCode(t, w) != "oidc_denied" {
+			t.Fatalf("status = %d, body %s, want 401 oidc_denied", w.Code, w.Body.String())
+		}
+	})
+}
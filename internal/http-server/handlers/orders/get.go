@@ -5,13 +5,24 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/go-chi/chi/v5"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/auth"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/config"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/metrics"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/pagination"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 )
 
 type Order struct {
@@ -20,15 +31,32 @@ type Order struct {
 	Accrual float64 `json:"accrual,omitempty"`
 }
 
+// accrualTimeout bounds a request to an accrual system, so one that hangs
+// holds up a poll for a single request at most.
+const accrualTimeout = 10 * time.Second
+
+// accrualClient propagates the trace context to accrual systems.
+var accrualClient = &http.Client{Timeout: accrualTimeout, Transport: tracing.Transport(http.DefaultTransport)}
+
+// errAccrualUnavailable is returned when an accrual system did not answer or
+// answered with an unexpected status; its other orders wait for the next poll.
+var errAccrualUnavailable = errors.New("accrual system unavailable")
+
+// Statuses are the order statuses accepted by list filters.
+var Statuses = []string{"NEW", "REGISTERED", "PROCESSING", "INVALID", "PROCESSED"}
+
 type OrderGetter interface {
-	// GetOrders GetOrder(ctx context.Context, orderID string) (postgres.Order, error)
-	GetOrders(ctx context.Context, login string) ([]postgres.Order, error)
+	GetOrder(ctx context.Context, orderID string) (postgres.OrderDetails, error)
+	GetOrders(ctx context.Context, login string, filter postgres.ListFilter) ([]postgres.Order, error)
+}
+
+type AccrualApplier interface {
+	ApplyAccrual(ctx context.Context, merchantID int64, accrual float64, status, orderID, source string) (bool, error)
 }
 
 type DataUpdater interface {
-	GetUnfinishedOrders() ([]string, error)
-	UpdateBalancePlus(ctx context.Context, amount float64, orderID string) error
-	UpdateOrderStatus(ctx context.Context, accrual float64, status, orderID string) error
+	AccrualApplier
+	GetUnfinishedOrders() ([]postgres.UnfinishedOrder, error)
 }
 
 func GetOrdersHandle(orderGetter OrderGetter) http.HandlerFunc {
@@ -36,25 +64,42 @@ func GetOrdersHandle(orderGetter OrderGetter) http.HandlerFunc {
 		authHeader := r.Header.Get("Authorization")
 
 		if authHeader == "" {
-			http.Error(w, "User not authorized", http.StatusUnauthorized)
+			problem.Error(w, r, "User not authorized", http.StatusUnauthorized)
 			return
 		}
 
-		login := auth.GetUserID(authHeader)
+		login := auth.RequestLogin(r)
 
-		orders, err := orderGetter.GetOrders(r.Context(), login)
+		filter, err := pagination.ParseFilter(r, Statuses)
+		if err != nil {
+			problem.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		limit := filter.Limit
+		if limit > 0 {
+			filter.Limit++
+		}
+
+		orders, err := orderGetter.GetOrders(r.Context(), login, filter)
 		if err != nil {
 			if errors.Is(err, storage.ErrNoOrders) {
-				http.Error(w, "No orders found", http.StatusNoContent)
+				problem.Error(w, r, "No orders found", http.StatusNoContent)
 				return
 			}
-			http.Error(w, "Error getting orders", http.StatusInternalServerError)
+			problem.Internal(w, r, err)
 			return
 		}
 
+		if limit > 0 && len(orders) > limit {
+			orders = orders[:limit]
+			last := orders[limit-1]
+			pagination.SetNextLink(w, r, postgres.Cursor{At: last.UploadedAt, ID: last.ID})
+		}
+
 		response, err := json.Marshal(orders)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problem.Internal(w, r, err)
 			return
 		}
 
@@ -64,63 +109,220 @@ func GetOrdersHandle(orderGetter OrderGetter) http.HandlerFunc {
 	}
 }
 
-func ActualiseOrderData(updater DataUpdater) error {
-	url := config.AccrualSystemAddress + "/api/orders/"
+// GetOrderHandle returns one of the user's orders with its status history and
+// linked withdrawals. Orders of other users are reported as forbidden.
+func GetOrderHandle(orderGetter OrderGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			problem.Error(w, r, "User not authorized", http.StatusUnauthorized)
+			return
+		}
+
+		login := auth.RequestLogin(r)
+		if login == "" {
+			problem.Error(w, r, "User not authorized", http.StatusUnauthorized)
+			return
+		}
+
+		order, err := orderGetter.GetOrder(r.Context(), chi.URLParam(r, "number"))
+		if err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
 
+		if order.Login != login {
+			problem.Error(w, r, "Order belongs to another user", http.StatusForbidden)
+			return
+		}
+
+		response, err := json.Marshal(order)
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(response)
+	}
+}
+
+// maxConcurrentMerchants bounds how many accrual systems are polled at once.
+const maxConcurrentMerchants = 8
+
+// ActualiseOrderData polls the accrual systems for the unfinished orders,
+// each merchant in its own goroutine so a slow one does not hold up the
+// others. Accrual systems sending webhooks are only polled every
+// config.ReconcileInterval. A failing order is logged and left for the next
+// poll; so are the remaining orders of an accrual system that did not
+// answer.
+func ActualiseOrderData(updater DataUpdater) error {
 	orders, err := updater.GetUnfinishedOrders()
 	if err != nil {
 		return fmt.Errorf("error getting unfinished orders: %w", err)
 	}
 
-	slog.Info("Processing orders: ", orders)
-	for _, orderID := range orders {
-		order, err := updateOrderData(url, orderID)
-		if err != nil {
+	slog.Debug("processing orders", "count", len(orders))
+	metrics.SetAccrualBacklog(len(orders))
+
+	var merchants []int64
+	byMerchant := make(map[int64][]postgres.UnfinishedOrder)
+	for _, unfinished := range orders {
+		id := unfinished.Merchant.ID
+		if _, ok := byMerchant[id]; !ok {
+			merchants = append(merchants, id)
+		}
+		byMerchant[id] = append(byMerchant[id], unfinished)
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentMerchants)
+	for _, id := range merchants {
+		if !accrualReconciles.due(byMerchant[id][0].Merchant) {
 			continue
 		}
-		if err := updater.UpdateBalancePlus(context.Background(), order.Accrual, order.Number); err != nil {
-			return err
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(orders []postgres.UnfinishedOrder) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			pollMerchant(updater, orders)
+		}(byMerchant[id])
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// pollMerchant syncs the unfinished orders of one merchant in turn, stopping
+// when its accrual system does not answer.
+func pollMerchant(updater DataUpdater, orders []postgres.UnfinishedOrder) {
+	for _, unfinished := range orders {
+		merchant := unfinished.Merchant
+		if !accrualThrottles.allow(merchant) {
+			continue
 		}
 
-		if err := updater.UpdateOrderStatus(context.Background(), order.Accrual, order.Status, order.Number); err != nil {
-			return err
+		baseURL := merchant.AccrualURL
+		if baseURL == "" {
+			baseURL = config.AccrualSystemAddress
 		}
+
+		err := syncOrder(updater, baseURL, unfinished)
+		switch {
+		case err == nil:
+		case errors.Is(err, errAccrualUnavailable):
+			slog.Warn("accrual system is unavailable, skipping its orders until the next poll", "merchant", merchant.ID, "order", unfinished.Number, "error", err)
+			return
+		default:
+			slog.Error("failed to sync order", "merchant", merchant.ID, "order", unfinished.Number, "error", err)
+		}
+	}
+}
+
+// syncOrder fetches an order from its accrual system and applies the result,
+// both under one span so the HTTP call and the queries share a trace.
+func syncOrder(updater DataUpdater, baseURL string, unfinished postgres.UnfinishedOrder) error {
+	ctx, span := tracing.Tracer().Start(context.Background(), "accrual.sync", trace.WithAttributes(
+		attribute.String("order.number", unfinished.Number),
+		attribute.Int64("merchant.id", unfinished.Merchant.ID),
+	))
+	defer span.End()
+
+	order, err := updateOrderData(ctx, baseURL+"/api/orders/", unfinished.Number, unfinished.Merchant)
+	if errors.Is(err, storage.ErrOrderNotFound) {
+		// Not registered in the accrual system yet.
+		return nil
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to fetch order")
+
+		var tooMany tooManyRequestsError
+		if errors.As(err, &tooMany) {
+			slog.InfoContext(ctx, "accrual system is throttling", "merchant", unfinished.Merchant.ID, "order", unfinished.Number, "retry_after", tooMany.retryAfter)
+			accrualThrottles.pause(unfinished.Merchant.ID, tooMany.retryAfter)
+			return nil
+		}
+
+		return err
+	}
+
+	if err := applyAccrual(ctx, updater, unfinished.Merchant.ID, order, postgres.StatusSourcePoll); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to apply accrual")
+		return err
 	}
 
 	return nil
 }
 
-func updateOrderData(baseURL, orderID string) (Order, error) {
-	var order Order
+// applyAccrual is the single crediting path shared by polling and webhooks.
+// merchantID is the merchant that reported the order, 0 for the default
+// accrual system.
+func applyAccrual(ctx context.Context, applier AccrualApplier, merchantID int64, order Order, source string) error {
+	changed, err := applier.ApplyAccrual(ctx, merchantID, order.Accrual, order.Status, order.Number, source)
+	if err != nil {
+		return fmt.Errorf("error applying accrual for order %s: %w", order.Number, err)
+	}
+	if changed {
+		slog.InfoContext(ctx, "order status changed", "order", order.Number, "status", order.Status, "accrual", order.Accrual, "source", source)
+	}
+
+	return nil
+}
+
+// updateOrderData fetches an order from an accrual system. An order the
+// system does not know yet is reported as storage.ErrOrderNotFound.
+func updateOrderData(ctx context.Context, baseURL, orderID string, merchant postgres.Merchant) (Order, error) {
 	url := baseURL + orderID
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return order, fmt.Errorf("error creating request for order data: %w", err)
+		return Order{}, fmt.Errorf("error creating request for order data: %w", err)
+	}
+	if merchant.Credentials != "" {
+		req.Header.Set("Authorization", merchant.Credentials)
 	}
 
-	client := http.Client{}
-	res, err := client.Do(req)
+	start := time.Now()
+	res, err := accrualClient.Do(req)
 	if err != nil {
-		return Order{}, fmt.Errorf("error sending request for order data: %w", err)
+		metrics.ObserveAccrualRequest(merchant.Name, "error", time.Since(start))
+		return Order{}, fmt.Errorf("%w: %w", errAccrualUnavailable, err)
 	}
 	defer res.Body.Close()
+	metrics.ObserveAccrualRequest(merchant.Name, strconv.Itoa(res.StatusCode), time.Since(start))
 
 	switch res.StatusCode {
 	case http.StatusTooManyRequests:
-		return Order{}, storage.ErrTooManyRequests
+		retryAfter := defaultRetryAfter
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		return Order{}, tooManyRequestsError{retryAfter: retryAfter}
 	case http.StatusNoContent:
 		return Order{}, storage.ErrOrderNotFound
-	case http.StatusInternalServerError:
-		return Order{}, fmt.Errorf("accrual server error: %w", err)
+	case http.StatusOK:
+	default:
+		return Order{}, fmt.Errorf("%w: status %d", errAccrualUnavailable, res.StatusCode)
 	}
 
-	if res.StatusCode == http.StatusOK {
-		body, _ := io.ReadAll(res.Body)
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return Order{}, fmt.Errorf("error reading order: %w", err)
+	}
 
-		if err := json.Unmarshal(body, &order); err != nil {
-			return Order{}, fmt.Errorf("error decoding order: %w", err)
-		}
+	var order Order
+	if err := json.Unmarshal(body, &order); err != nil {
+		return Order{}, fmt.Errorf("error decoding order: %w", err)
+	}
+	if order.Number != orderID {
+		return Order{}, fmt.Errorf("accrual system returned order %q instead of %q", order.Number, orderID)
 	}
 
 	return order, nil
@@ -0,0 +1,191 @@
+package orders
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/config"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type pollStore struct {
+	orders []postgres.UnfinishedOrder
+
+	mu      sync.Mutex
+	applied map[string]string
+}
+
+func (s *pollStore) GetUnfinishedOrders() ([]postgres.UnfinishedOrder, error) {
+	return s.orders, nil
+}
+
+func (s *pollStore) ApplyAccrual(_ context.Context, _ int64, _ float64, status, orderID, _ string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.applied[orderID] = status
+
+	return true, nil
+}
+
+func TestActualiseOrderDataSkipsFailingMerchant(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		number := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+		mu.Lock()
+		requests[number]++
+		mu.Unlock()
+
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/down/"):
+			w.WriteHeader(http.StatusBadGateway)
+		case number == "wrong":
+			json.NewEncoder(w).Encode(Order{Number: "other", Status: "PROCESSED", Accrual: 100})
+		default:
+			json.NewEncoder(w).Encode(Order{Number: number, Status: "PROCESSED", Accrual: 10})
+		}
+	}))
+	defer accrual.Close()
+
+	down := postgres.Merchant{ID: 101, AccrualURL: accrual.URL + "/down"}
+	up := postgres.Merchant{ID: 102, AccrualURL: accrual.URL}
+
+	store := &pollStore{
+		orders: []postgres.UnfinishedOrder{
+			{Number: "d1", Merchant: down},
+			{Number: "u1", Merchant: up},
+			{Number: "d2", Merchant: down},
+			{Number: "wrong", Merchant: up},
+			{Number: "u2", Merchant: up},
+		},
+		applied: make(map[string]string),
+	}
+
+	if err := ActualiseOrderData(store); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, number := range []string{"u1", "u2"} {
+		if store.applied[number] != "PROCESSED" {
+			t.Errorf("order %s was not applied", number)
+		}
+	}
+	for _, number := range []string{"d1", "d2", "wrong"} {
+		if _, ok := store.applied[number]; ok {
+			t.Errorf("order %s was applied", number)
+		}
+	}
+	if requests["d2"] != 0 {
+		t.Errorf("unavailable accrual system was asked again in the same poll")
+	}
+}
+
+func TestActualiseOrderDataPollsMerchantsConcurrently(t *testing.T) {
+	release := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer hanging.Close()
+	defer close(release)
+
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		number := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		json.NewEncoder(w).Encode(Order{Number: number, Status: "PROCESSED", Accrual: 10})
+	}))
+	defer accrual.Close()
+
+	slow := postgres.Merchant{ID: 201, AccrualURL: hanging.URL}
+	up := postgres.Merchant{ID: 202, AccrualURL: accrual.URL}
+
+	store := &pollStore{
+		orders: []postgres.UnfinishedOrder{
+			{Number: "s1", Merchant: slow},
+			{Number: "u1", Merchant: up},
+		},
+		applied: make(map[string]string),
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- ActualiseOrderData(store) }()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		store.mu.Lock()
+		_, ok := store.applied["u1"]
+		store.mu.Unlock()
+		if ok {
+			break
+		}
+
+		select {
+		case err := <-done:
+			t.Fatalf("poll finished with %v before the hanging merchant answered", err)
+		case <-deadline:
+			t.Fatal("order of the responsive merchant waited for the hanging one")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	select {
+	case <-done:
+		t.Fatal("poll finished before the hanging merchant answered")
+	default:
+	}
+	release <- struct{}{}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestActualiseOrderDataReconcilesWebhookMerchants(t *testing.T) {
+	interval := config.ReconcileInterval
+	config.ReconcileInterval = time.Hour
+	t.Cleanup(func() { config.ReconcileInterval = interval })
+
+	var mu sync.Mutex
+	requests := make(map[string]int)
+
+	accrual := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		number := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+		mu.Lock()
+		requests[number]++
+		mu.Unlock()
+
+		json.NewEncoder(w).Encode(Order{Number: number, Status: "PROCESSING"})
+	}))
+	defer accrual.Close()
+
+	pushing := postgres.Merchant{ID: 301, AccrualURL: accrual.URL, WebhookSecret: "secret"}
+	polled := postgres.Merchant{ID: 302, AccrualURL: accrual.URL}
+
+	store := &pollStore{
+		orders: []postgres.UnfinishedOrder{
+			{Number: "w1", Merchant: pushing},
+			{Number: "p1", Merchant: polled},
+		},
+		applied: make(map[string]string),
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := ActualiseOrderData(store); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if requests["w1"] != 1 {
+		t.Errorf("order of a merchant sending webhooks was polled %d times, want once per reconcile interval", requests["w1"])
+	}
+	if requests["p1"] != 3 {
+		t.Errorf("order of a merchant without webhooks was polled %d times, want every poll", requests["p1"])
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/auth"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/validation"
 	"io"
 	"net/http"
@@ -12,48 +14,60 @@ import (
 )
 
 type OrderLoader interface {
-	LoadOrder(ctx context.Context, login, orderID string) error
+	LoadOrder(ctx context.Context, login, orderID string, merchantID int64) error
+	GetMerchantByName(ctx context.Context, name string) (postgres.Merchant, error)
 }
 
 func LoadOrderHandle(orderLoader OrderLoader) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			http.Error(w, "User not authorized", http.StatusUnauthorized)
+			problem.Error(w, r, "User not authorized", http.StatusUnauthorized)
 			return
 		}
 
-		login := auth.GetUserID(authHeader)
+		login := auth.RequestLogin(r)
 
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, "Error reading request body", http.StatusBadRequest)
+			problem.Error(w, r, "Error reading request body", http.StatusBadRequest)
 			return
 		}
 		orderID := string(body)
 		if orderID == "" {
-			http.Error(w, "No order ID provided", http.StatusBadRequest)
+			problem.Error(w, r, "No order ID provided", http.StatusBadRequest)
 			return
 		}
 
 		intOrderID, _ := strconv.Atoi(orderID)
 		if !validation.Valid(intOrderID) {
-			http.Error(w, "Invalid order ID", http.StatusUnprocessableEntity)
+			problem.Code(w, r, "Invalid order ID", "invalid_order_number", http.StatusUnprocessableEntity)
 			return
 		}
 
-		err = orderLoader.LoadOrder(r.Context(), login, orderID)
-		if err != nil {
-			if errors.Is(err, storage.ErrOrderAlreadyLoadedByUser) {
-				http.Error(w, "Order already loaded", http.StatusOK)
+		var merchantID int64
+		if name := r.URL.Query().Get("merchant"); name != "" {
+			merchant, err := orderLoader.GetMerchantByName(r.Context(), name)
+			if err != nil {
+				if errors.Is(err, storage.ErrMerchantNotFound) {
+					problem.Code(w, r, "Unknown merchant", "unknown_merchant", http.StatusBadRequest)
+					return
+				}
+
+				problem.Internal(w, r, err)
 				return
 			}
-			if errors.Is(err, storage.ErrOrderAlreadyLoadedByAnotherUser) {
-				http.Error(w, "Order already loaded by another user", http.StatusConflict)
+			merchantID = merchant.ID
+		}
+
+		err = orderLoader.LoadOrder(r.Context(), login, orderID, merchantID)
+		if err != nil {
+			if errors.Is(err, storage.ErrOrderAlreadyLoadedByUser) {
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("Order already loaded"))
 				return
 			}
-
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problem.FromError(w, r, err)
 			return
 		}
 
@@ -0,0 +1,74 @@
+package orders
+
+import (
+	"context"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/config"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/health"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Poll syncs unfinished orders with their accrual systems every interval
+// until ctx is cancelled. A failed poll is logged and retried on the next
+// tick.
+func Poll(ctx context.Context, updater DataUpdater, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	health.Register("accrual_poller", interval)
+	defer health.Exit("accrual_poller")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			health.Beat("accrual_poller")
+			if err := ActualiseOrderData(updater); err != nil {
+				slog.Error("failed to actualise order data", "error", err)
+			}
+		}
+	}
+}
+
+// reconciles remembers when the orders of accrual systems sending webhooks
+// were last polled. Their updates arrive by webhook, so polling only
+// reconciles missed ones every config.ReconcileInterval instead of on
+// every tick.
+type reconciles struct {
+	mu   sync.Mutex
+	last map[int64]time.Time
+}
+
+var accrualReconciles = &reconciles{last: make(map[int64]time.Time)}
+
+// due reports whether the merchant's orders are to be polled now, and if so
+// counts this poll.
+func (r *reconciles) due(m postgres.Merchant) bool {
+	if !sendsWebhooks(m) {
+		return true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.last[m.ID]) < config.ReconcileInterval {
+		return false
+	}
+	r.last[m.ID] = now
+
+	return true
+}
+
+// sendsWebhooks reports whether the accrual system of m can push updates:
+// the default one with the global webhook secret, a merchant's with its own.
+func sendsWebhooks(m postgres.Merchant) bool {
+	if m.ID == 0 {
+		return config.AccrualWebhookSecret != ""
+	}
+
+	return m.WebhookSecret != ""
+}
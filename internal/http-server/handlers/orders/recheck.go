@@ -0,0 +1,77 @@
+package orders
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/config"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"log/slog"
+	"net/http"
+)
+
+type OrderRechecker interface {
+	AccrualApplier
+	GetOrder(ctx context.Context, orderID string) (postgres.OrderDetails, error)
+	RecheckOrder(ctx context.Context, orderID, actor string) (postgres.UnfinishedOrder, error)
+}
+
+// RecheckOrderHandle asks the accrual system about an order right away
+// instead of waiting for the poller, reopening it first if it was INVALID.
+// If the accrual system does not answer, the order is left to polling.
+func RecheckOrderHandle(rechecker OrderRechecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		number := chi.URLParam(r, "number")
+
+		unfinished, err := rechecker.RecheckOrder(r.Context(), number, middleware.Actor(r.Context()))
+		if err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		baseURL := unfinished.Merchant.AccrualURL
+		if baseURL == "" {
+			baseURL = config.AccrualSystemAddress
+		}
+
+		order, err := updateOrderData(r.Context(), baseURL+"/api/orders/", number, unfinished.Merchant)
+		switch {
+		case errors.Is(err, storage.ErrOrderNotFound):
+			slog.InfoContext(r.Context(), "order is not registered in the accrual system", "order", number)
+		case err != nil:
+			var tooMany tooManyRequestsError
+			if errors.As(err, &tooMany) {
+				accrualThrottles.pause(unfinished.Merchant.ID, tooMany.retryAfter)
+			}
+
+			slog.WarnContext(r.Context(), "order recheck failed", "order", number, "error", err)
+			problem.Code(w, r, "Accrual system did not answer, the order is left to polling", "accrual_unavailable", http.StatusBadGateway)
+			return
+		default:
+			if err := applyAccrual(r.Context(), rechecker, unfinished.Merchant.ID, order, postgres.StatusSourceAdmin); err != nil {
+				problem.Internal(w, r, err)
+				return
+			}
+		}
+
+		details, err := rechecker.GetOrder(r.Context(), number)
+		if err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		response, err := json.Marshal(details)
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(response)
+	}
+}
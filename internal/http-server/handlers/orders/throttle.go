@@ -0,0 +1,82 @@
+package orders
+
+import (
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"golang.org/x/time/rate"
+	"sync"
+	"time"
+)
+
+const defaultRetryAfter = 60 * time.Second
+
+// merchantThrottle limits polling of a single accrual system. A rate limited
+// merchant is skipped rather than waited for, and one that does not answer
+// within accrualTimeout is skipped for the rest of the poll.
+type merchantThrottle struct {
+	limiter     *rate.Limiter
+	pausedUntil time.Time
+}
+
+type throttles struct {
+	mu sync.Mutex
+	m  map[int64]*merchantThrottle
+}
+
+var accrualThrottles = &throttles{m: make(map[int64]*merchantThrottle)}
+
+// allow reports whether a request to the merchant's accrual system may be sent now.
+// Limits are re-read on every call so admin changes apply without a restart.
+func (t *throttles) allow(m postgres.Merchant) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	limit := rate.Inf
+	if m.RateLimit > 0 {
+		limit = rate.Limit(m.RateLimit)
+	}
+	burst := m.Burst
+	if burst < 1 {
+		burst = 1
+	}
+
+	th, ok := t.m[m.ID]
+	if !ok {
+		th = &merchantThrottle{limiter: rate.NewLimiter(limit, burst)}
+		t.m[m.ID] = th
+	}
+	if th.limiter.Limit() != limit {
+		th.limiter.SetLimit(limit)
+	}
+	if th.limiter.Burst() != burst {
+		th.limiter.SetBurst(burst)
+	}
+
+	if time.Now().Before(th.pausedUntil) {
+		return false
+	}
+
+	return th.limiter.Allow()
+}
+
+// pause stops polling the merchant's accrual system for d after it answered 429.
+func (t *throttles) pause(merchantID int64, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if th, ok := t.m[merchantID]; ok {
+		th.pausedUntil = time.Now().Add(d)
+	}
+}
+
+type tooManyRequestsError struct {
+	retryAfter time.Duration
+}
+
+func (e tooManyRequestsError) Error() string {
+	return storage.ErrTooManyRequests.Error()
+}
+
+func (e tooManyRequestsError) Unwrap() error {
+	return storage.ErrTooManyRequests
+}
@@ -0,0 +1,91 @@
+package orders
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/config"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/validation"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const webhookTolerance = 5 * time.Minute
+
+type WebhookReceiver interface {
+	AccrualApplier
+	GetMerchantByName(ctx context.Context, name string) (postgres.Merchant, error)
+	SaveWebhookSignature(ctx context.Context, signature string) (bool, error)
+}
+
+// AccrualWebhookHandle accepts order status pushes from an accrual system.
+// Requests are signed with HMAC-SHA256 over "<timestamp>.<body>" using the
+// merchant's webhook secret, or the global one when X-Merchant is not set.
+// A merchant may only update its own orders, the global secret only orders
+// of the default accrual system.
+func AccrualWebhookHandle(receiver WebhookReceiver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			problem.Error(w, r, "Error reading request body", http.StatusBadRequest)
+			return
+		}
+
+		var merchantID int64
+		secret := config.AccrualWebhookSecret
+		if name := r.Header.Get("X-Merchant"); name != "" {
+			merchant, err := receiver.GetMerchantByName(r.Context(), name)
+			if err != nil {
+				if errors.Is(err, storage.ErrMerchantNotFound) {
+					problem.Error(w, r, "Invalid signature", http.StatusUnauthorized)
+					return
+				}
+
+				problem.Internal(w, r, err)
+				return
+			}
+			merchantID, secret = merchant.ID, merchant.WebhookSecret
+		}
+
+		timestamp, err := strconv.ParseInt(r.Header.Get("X-Accrual-Timestamp"), 10, 64)
+		if err != nil {
+			problem.Error(w, r, "Invalid timestamp", http.StatusUnauthorized)
+			return
+		}
+
+		signature := r.Header.Get("X-Accrual-Signature")
+		if !validation.CheckSignature(secret, signature, timestamp, body, webhookTolerance) {
+			problem.Error(w, r, "Invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		fresh, err := receiver.SaveWebhookSignature(r.Context(), signature)
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+		if !fresh {
+			problem.Error(w, r, "Webhook already processed", http.StatusConflict)
+			return
+		}
+
+		var order Order
+
+		if err := json.Unmarshal(body, &order); err != nil || order.Number == "" || order.Status == "" {
+			problem.Error(w, r, "Error parsing request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := applyAccrual(r.Context(), receiver, merchantID, order, postgres.StatusSourceWebhook); err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
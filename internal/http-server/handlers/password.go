@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-playground/validator/v10"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/auth"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/clientip"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/notify"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type PasswordChangeData struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required"`
+}
+
+type PasswordResetRequestData struct {
+	Login string `json:"login" validate:"required"`
+}
+
+type PasswordResetData struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required"`
+}
+
+type PasswordChanger interface {
+	Authenticator
+	ChangePassword(ctx context.Context, login, password string) (int, error)
+}
+
+type PasswordResetCreator interface {
+	CreatePasswordReset(ctx context.Context, login string, ttl time.Duration) (string, time.Time, error)
+}
+
+type PasswordResetter interface {
+	ResetPassword(ctx context.Context, token, password string) (string, error)
+}
+
+// ChangePasswordHandle sets a new password after checking the current one.
+// Other sessions are revoked; the caller gets a fresh token.
+func ChangePasswordHandle(changer PasswordChanger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login := auth.GetUserID(r.Header.Get("Authorization"))
+		if login == "" {
+			problem.Error(w, r, "User not authorized", http.StatusUnauthorized)
+			return
+		}
+
+		var data PasswordChangeData
+
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			problem.Error(w, r, "Error parsing request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := validator.New().Struct(data); err != nil {
+			problem.Error(w, r, "Current and new password are required", http.StatusBadRequest)
+			return
+		}
+
+		err := Authenticate(r.Context(), changer, login, data.CurrentPassword, clientip.Get(r.Context()))
+		if err != nil {
+			slog.InfoContext(r.Context(), "password change refused", "user", login, "error", err)
+			problem.FromError(w, r, err)
+			return
+		}
+
+		version, err := changer.ChangePassword(r.Context(), login, data.NewPassword)
+		if err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		tokenString, err := auth.BuildJWTString(login, version)
+		if err != nil {
+			problem.Internal(w, r, err)
+			return
+		}
+
+		slog.InfoContext(r.Context(), "password changed", "user", login)
+
+		w.Header().Set("Authorization", tokenString)
+		w.WriteHeader(http.StatusOK)
+
+		w.Write([]byte(login))
+	}
+}
+
+// RequestPasswordResetHandle sends a reset token through the notifier. The
+// answer is the same whether the login exists or not.
+func RequestPasswordResetHandle(creator PasswordResetCreator, notifier notify.Notifier, ttl time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var data PasswordResetRequestData
+
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			problem.Error(w, r, "Error parsing request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := validator.New().Struct(data); err != nil {
+			problem.Error(w, r, "Login is required", http.StatusBadRequest)
+			return
+		}
+
+		token, expiresAt, err := creator.CreatePasswordReset(r.Context(), data.Login, ttl)
+		switch {
+		case errors.Is(err, storage.ErrUserNotFound):
+			slog.InfoContext(r.Context(), "password reset requested for unknown user", "user", data.Login)
+		case err != nil:
+			problem.Internal(w, r, err)
+			return
+		default:
+			if err := notifier.PasswordReset(r.Context(), data.Login, token, expiresAt); err != nil {
+				slog.ErrorContext(r.Context(), "failed to deliver password reset", "user", data.Login, "error", err)
+			}
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// ResetPasswordHandle sets a new password with a reset token.
+func ResetPasswordHandle(resetter PasswordResetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var data PasswordResetData
+
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			problem.Error(w, r, "Error parsing request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := validator.New().Struct(data); err != nil {
+			problem.Error(w, r, "Token and new password are required", http.StatusBadRequest)
+			return
+		}
+
+		login, err := resetter.ResetPassword(r.Context(), data.Token, data.NewPassword)
+		if err != nil {
+			problem.FromError(w, r, err)
+			return
+		}
+
+		slog.InfoContext(r.Context(), "password reset", "user", login)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -3,10 +3,9 @@ package handlers
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"github.com/go-playground/validator/v10"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/auth"
-	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
 	"log/slog"
 	"net/http"
 )
@@ -23,45 +22,39 @@ type UserSaver interface {
 func RegistrationHandle(userSaver UserSaver) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			slog.Info("invalid method", r.Method)
-			http.Error(w, "Method is not allowed", http.StatusMethodNotAllowed)
+			slog.InfoContext(r.Context(), "invalid method", "method", r.Method)
+			problem.Error(w, r, "Method is not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
 		var data RegistrationData
 
 		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-			slog.Info("invalid register request", err)
+			slog.InfoContext(r.Context(), "invalid register request", "error", err)
 
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			problem.Error(w, r, "Error parsing request body", http.StatusBadRequest)
 			return
 		}
 
 		if err := validator.New().Struct(data); err != nil {
-			slog.Info("invalid validation for reg", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			slog.InfoContext(r.Context(), "invalid validation for reg", "error", err)
+			problem.Error(w, r, "Login and password are required", http.StatusBadRequest)
 			return
 		}
 
 		err := userSaver.SaveUser(r.Context(), data.Login, data.Password)
-		if errors.Is(err, storage.ErrLoginAlreadyExists) {
-			slog.Info("user already exists", data.Login)
-
-			http.Error(w, "user already exists", http.StatusConflict)
-			return
-		}
 		if err != nil {
-			slog.Info("failed to save user while reg", err)
+			slog.InfoContext(r.Context(), "failed to save user while reg", "user", data.Login, "error", err)
 
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			problem.FromError(w, r, err)
 			return
 		}
 
-		slog.Info("user saved")
-		tokenString, err := auth.BuildJWTString(data.Login)
+		slog.InfoContext(r.Context(), "user saved", "user", data.Login)
+		tokenString, err := auth.BuildJWTString(data.Login, 0)
 		if err != nil {
-			slog.Info("failed to create JWT token", err)
-			http.Error(w, "failed to create JWT token", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "failed to create JWT token", "user", data.Login, "error", err)
+			problem.Internal(w, r, err)
 			return
 		}
 
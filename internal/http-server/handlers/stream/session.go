@@ -0,0 +1,45 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/auth"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/session"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"time"
+)
+
+// sessionCheckInterval is how often an open stream re-checks its JWT, so a
+// logout, password change or disabled account ends it within that time.
+// Tests shorten it.
+var sessionCheckInterval = 30 * time.Second
+
+var errSessionExpired = errors.New("session expired")
+
+// checkSession reports why the session of claims may no longer receive
+// events: the token expired, was revoked or the account was disabled.
+func checkSession(ctx context.Context, sessions session.VersionGetter, claims *auth.Claims) error {
+	if claims.ExpiresAt != nil && !time.Now().Before(claims.ExpiresAt.Time) {
+		return errSessionExpired
+	}
+
+	version, err := sessions.GetSessionVersion(ctx, claims.Login)
+	if err != nil {
+		return err
+	}
+	if version != claims.SessionVersion {
+		return storage.ErrSessionRevoked
+	}
+
+	return nil
+}
+
+// endReason is what the client is told when checkSession fails with err.
+func endReason(err error) string {
+	switch {
+	case errors.Is(err, errSessionExpired), errors.Is(err, storage.ErrSessionRevoked), errors.Is(err, storage.ErrAccountDisabled):
+		return err.Error()
+	default:
+		return "session check failed"
+	}
+}
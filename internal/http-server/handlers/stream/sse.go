@@ -0,0 +1,89 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/auth"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/events"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/session"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const keepAliveInterval = 15 * time.Second
+
+type Subscriber interface {
+	Subscribe(login string) (<-chan events.Event, func())
+}
+
+// EventsHandle streams the user's order, balance and withdrawal events
+// as Server-Sent Events until the client disconnects. The session is
+// re-checked every sessionCheckInterval; once the JWT expired or was revoked,
+// or the account was disabled, an error event is sent and the stream ends.
+func EventsHandle(subscriber Subscriber, sessions session.VersionGetter) http.HandlerFunc {
+	recheckInterval := sessionCheckInterval
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			problem.Error(w, r, "User not authorized", http.StatusUnauthorized)
+			return
+		}
+
+		claims := auth.GetClaims(authHeader)
+		if claims == nil || claims.Login == "" {
+			problem.Error(w, r, "User not authorized", http.StatusUnauthorized)
+			return
+		}
+		login := claims.Login
+
+		rc := http.NewResponseController(w)
+
+		ch, unsubscribe := subscriber.Subscribe(login)
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		keepAlive := time.NewTicker(keepAliveInterval)
+		defer keepAlive.Stop()
+
+		recheck := time.NewTicker(recheckInterval)
+		defer recheck.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-recheck.C:
+				if err := checkSession(r.Context(), sessions, claims); err != nil {
+					slog.InfoContext(r.Context(), "closing event stream", "user", login, "reason", err)
+					data, _ := json.Marshal(ServerMessage{Type: "error", Error: endReason(err)})
+					fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+					rc.Flush()
+					return
+				}
+				continue
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+			case e := <-ch:
+				if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, e.Data); err != nil {
+					return
+				}
+			}
+
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package stream
+
+import (
+	"bufio"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/auth"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventsEndWithRevokedSession(t *testing.T) {
+	interval := sessionCheckInterval
+	sessionCheckInterval = 10 * time.Millisecond
+	t.Cleanup(func() { sessionCheckInterval = interval })
+
+	token, err := auth.BuildJWTString("sse-user", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(EventsHandle(testSubscriber{}, testSessions{"sse-user": 1}))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", token)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("stream was not ended: %v", err)
+	}
+
+	got := strings.Join(lines, "\n")
+	want := "event: error\ndata: {\"type\":\"error\",\"error\":\"session revoked\"}"
+	if !strings.Contains(got, want) {
+		t.Errorf("stream = %q, want it to end with %q", got, want)
+	}
+}
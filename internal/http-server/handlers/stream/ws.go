@@ -0,0 +1,222 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/auth"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/events"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/session"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	writeWait      = 10 * time.Second
+	pongWait       = 60 * time.Second
+	pingPeriod     = pongWait * 9 / 10
+	maxMessageSize = 4096
+
+	// maxSubscriptions bounds the orders a connection follows; a client
+	// subscribing to more is disconnected with a policy violation.
+	maxSubscriptions = 100
+)
+
+var (
+	errUnknownAction        = errors.New("unknown action")
+	errTooManySubscriptions = errors.New("too many subscriptions")
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// ClientMessage is sent by the client to choose which orders it follows.
+// Without subscriptions every order of the user is pushed.
+type ClientMessage struct {
+	Action string   `json:"action"`
+	Orders []string `json:"orders"`
+}
+
+type ServerMessage struct {
+	Type  string          `json:"type"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+type subscriptions struct {
+	mu     sync.Mutex
+	orders map[string]struct{}
+}
+
+func (s *subscriptions) update(msg ClientMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch msg.Action {
+	case "subscribe":
+		added := 0
+		for _, number := range msg.Orders {
+			if _, ok := s.orders[number]; !ok {
+				added++
+			}
+		}
+		if len(s.orders)+added > maxSubscriptions {
+			return errTooManySubscriptions
+		}
+
+		for _, number := range msg.Orders {
+			s.orders[number] = struct{}{}
+		}
+	case "unsubscribe":
+		for _, number := range msg.Orders {
+			delete(s.orders, number)
+		}
+	default:
+		return errUnknownAction
+	}
+
+	return nil
+}
+
+func (s *subscriptions) wants(e events.Event) bool {
+	if e.Type != events.OrderStatusChanged {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.orders) == 0 {
+		return true
+	}
+
+	var order struct {
+		Number string `json:"number"`
+	}
+	if err := json.Unmarshal(e.Data, &order); err != nil {
+		return false
+	}
+	_, ok := s.orders[order.Number]
+
+	return ok
+}
+
+// WebSocketHandle pushes the user's order and balance events over a WebSocket.
+// The JWT is taken from the Authorization header or, for browsers, the token
+// query parameter. Like EventsHandle it re-checks the session and closes the
+// connection with a policy violation once the session has ended.
+func WebSocketHandle(subscriber Subscriber, sessions session.VersionGetter) http.HandlerFunc {
+	recheckInterval := sessionCheckInterval
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Authorization")
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+
+		claims := auth.GetClaims(token)
+		if claims == nil || claims.Login == "" {
+			problem.Error(w, r, "User not authorized", http.StatusUnauthorized)
+			return
+		}
+		login := claims.Login
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			slog.InfoContext(r.Context(), "failed to upgrade websocket", "error", err)
+			return
+		}
+		defer conn.Close()
+
+		ch, unsubscribe := subscriber.Subscribe(login)
+		defer unsubscribe()
+
+		subs := &subscriptions{orders: make(map[string]struct{})}
+		replies := make(chan ServerMessage, 1)
+		done := make(chan struct{})
+
+		go readLoop(conn, subs, replies, done)
+
+		ping := time.NewTicker(pingPeriod)
+		defer ping.Stop()
+
+		recheck := time.NewTicker(recheckInterval)
+		defer recheck.Stop()
+
+		for {
+			var msg ServerMessage
+
+			select {
+			case <-done:
+				return
+			case <-recheck.C:
+				if err := checkSession(r.Context(), sessions, claims); err != nil {
+					slog.InfoContext(r.Context(), "closing websocket", "user", login, "reason", err)
+					closeMsg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, endReason(err))
+					conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(writeWait))
+					return
+				}
+				continue
+			case <-ping.C:
+				conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+					return
+				}
+				continue
+			case msg = <-replies:
+			case e := <-ch:
+				if !subs.wants(e) {
+					continue
+				}
+				msg = ServerMessage{Type: e.Type, Data: e.Data}
+			}
+
+			// A client that cannot take a message within writeWait is dropped
+			// rather than letting its events pile up.
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteJSON(msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func readLoop(conn *websocket.Conn, subs *subscriptions, replies chan<- ServerMessage, done chan<- struct{}) {
+	defer close(done)
+
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var msg ClientMessage
+
+		reply := ServerMessage{Type: "ok"}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			reply = ServerMessage{Type: "error", Error: "invalid message"}
+		} else if err := subs.update(msg); errors.Is(err, errTooManySubscriptions) {
+			closeMsg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error())
+			conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(writeWait))
+			return
+		} else if err != nil {
+			reply = ServerMessage{Type: "error", Error: err.Error()}
+		}
+
+		select {
+		case replies <- reply:
+		default:
+		}
+	}
+}
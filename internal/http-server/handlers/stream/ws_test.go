@@ -0,0 +1,149 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/auth"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/events"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testSubscriber struct{}
+
+func (testSubscriber) Subscribe(string) (<-chan events.Event, func()) {
+	return make(chan events.Event), func() {}
+}
+
+// testSessions holds the session version of every user, 0 unless set.
+type testSessions map[string]int
+
+func (s testSessions) GetSessionVersion(_ context.Context, login string) (int, error) {
+	if s[login] < 0 {
+		return 0, storage.ErrAccountDisabled
+	}
+
+	return s[login], nil
+}
+
+func orderNumbers(from, n int) []string {
+	numbers := make([]string, n)
+	for i := range numbers {
+		numbers[i] = fmt.Sprint(from + i)
+	}
+
+	return numbers
+}
+
+func TestSubscriptionsUpdate(t *testing.T) {
+	subs := &subscriptions{orders: make(map[string]struct{})}
+
+	if err := subs.update(ClientMessage{Action: "subscribe", Orders: orderNumbers(0, maxSubscriptions)}); err != nil {
+		t.Fatalf("subscribing up to the limit: %v", err)
+	}
+	if err := subs.update(ClientMessage{Action: "subscribe", Orders: []string{"0"}}); err != nil {
+		t.Errorf("subscribing again to a followed order: %v", err)
+	}
+	if err := subs.update(ClientMessage{Action: "subscribe", Orders: []string{"new"}}); !errors.Is(err, errTooManySubscriptions) {
+		t.Errorf("subscribing past the limit: err = %v, want %v", err, errTooManySubscriptions)
+	}
+	if _, ok := subs.orders["new"]; ok {
+		t.Error("order past the limit was subscribed")
+	}
+
+	if err := subs.update(ClientMessage{Action: "unsubscribe", Orders: []string{"0"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := subs.update(ClientMessage{Action: "subscribe", Orders: []string{"new"}}); err != nil {
+		t.Errorf("subscribing after unsubscribing: %v", err)
+	}
+
+	if err := subs.update(ClientMessage{Action: "follow"}); !errors.Is(err, errUnknownAction) {
+		t.Errorf("unknown action: err = %v, want %v", err, errUnknownAction)
+	}
+}
+
+func TestWebSocketClosesOnTooManySubscriptions(t *testing.T) {
+	token, err := auth.BuildJWTString("ws-user", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(WebSocketHandle(testSubscriber{}, testSessions{}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?token="+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteJSON(ClientMessage{Action: "subscribe", Orders: orderNumbers(0, maxSubscriptions+1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("err = %v, want a close with code %d", err, websocket.ClosePolicyViolation)
+	}
+}
+
+func TestWebSocketClosesEndedSession(t *testing.T) {
+	interval := sessionCheckInterval
+	sessionCheckInterval = 10 * time.Millisecond
+	t.Cleanup(func() { sessionCheckInterval = interval })
+
+	tests := []struct {
+		name     string
+		sessions testSessions
+		reason   string
+	}{
+		{"revoked", testSessions{"ws-user": 1}, storage.ErrSessionRevoked.Error()},
+		{"disabled", testSessions{"ws-user": -1}, storage.ErrAccountDisabled.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := auth.BuildJWTString("ws-user", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			srv := httptest.NewServer(WebSocketHandle(testSubscriber{}, tt.sessions))
+			defer srv.Close()
+
+			conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?token="+token, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, _, err = conn.ReadMessage()
+
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != tt.reason {
+				t.Errorf("err = %v, want a close with code %d and reason %q", err, websocket.ClosePolicyViolation, tt.reason)
+			}
+		})
+	}
+}
+
+func TestCheckSessionExpired(t *testing.T) {
+	token, err := auth.BuildJWTString("ws-user", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := auth.GetClaims(token)
+	claims.ExpiresAt.Time = time.Now().Add(-time.Second)
+
+	if err := checkSession(context.Background(), testSessions{}, claims); !errors.Is(err, errSessionExpired) {
+		t.Errorf("err = %v, want %v", err, errSessionExpired)
+	}
+}
@@ -0,0 +1,222 @@
+package openapi
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/go-chi/chi/v5"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// spec is maintained by hand. CheckRoutes keeps it in line with the router.
+//
+//go:embed openapi.yaml
+var spec []byte
+
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Gophermart API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.onload = () => { window.ui = SwaggerUIBundle({ url: "/api/openapi.json", dom_id: "#swagger-ui" }); };
+  </script>
+</body>
+</html>
+`
+
+// Load parses and validates the embedded OpenAPI document.
+func Load() (*openapi3.T, error) {
+	loader := openapi3.NewLoader()
+
+	doc, err := loader.LoadFromData(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load openapi document: %w", err)
+	}
+
+	if err := doc.Validate(loader.Context); err != nil {
+		return nil, fmt.Errorf("invalid openapi document: %w", err)
+	}
+
+	return doc, nil
+}
+
+func SpecHandle(doc *openapi3.T) (http.HandlerFunc, error) {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode openapi document: %w", err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}, nil
+}
+
+func DocsHandle() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(docsPage))
+	}
+}
+
+// CheckRoutes compares the routes registered on the router with the
+// operations of the document and lists every difference.
+func CheckRoutes(doc *openapi3.T, routes chi.Routes) error {
+	registered := make(map[string]bool)
+
+	err := chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		registered[method+" "+strings.ReplaceAll(route, "/*/", "/")] = true
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to walk routes: %w", err)
+	}
+
+	documented := make(map[string]bool)
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			documented[method+" "+path] = true
+		}
+	}
+
+	var diff []string
+	for route := range registered {
+		if !documented[route] {
+			diff = append(diff, "undocumented route "+route)
+		}
+	}
+	for route := range documented {
+		if !registered[route] {
+			diff = append(diff, "documented route is not registered: "+route)
+		}
+	}
+
+	if len(diff) > 0 {
+		sort.Strings(diff)
+		return errors.New(strings.Join(diff, "; "))
+	}
+
+	return nil
+}
+
+// Validator checks requests and responses against the document. Invalid
+// requests are rejected with 400 and responses that do not match are
+// replaced with 500, so spec drift surfaces in tests. Streaming operations
+// are passed through unchecked.
+func Validator(doc *openapi3.T) (func(http.Handler) http.Handler, error) {
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build openapi router: %w", err)
+	}
+
+	// Schema errors quote the offending values, which may be passwords.
+	openapi3.SchemaErrorDetailsDisabled = true
+
+	options := &openapi3filter.Options{
+		AuthenticationFunc:    openapi3filter.NoopAuthenticationFunc,
+		IncludeResponseStatus: true,
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route, pathParams, err := router.FindRoute(r)
+			if err != nil || streaming(route) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			input := &openapi3filter.RequestValidationInput{
+				Request:    r,
+				PathParams: pathParams,
+				Route:      route,
+				Options:    options,
+			}
+
+			if err := openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+				slog.InfoContext(r.Context(), "request does not match api specification", "operation", route.Operation.OperationID, "error", err)
+
+				problem.Code(w, r, "Request does not match API specification", "invalid_request", http.StatusBadRequest)
+				return
+			}
+
+			rec := &recorder{header: make(http.Header), status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			// net/http sniffs the content type of untyped bodies on write; do the same
+			// so the response is validated as the client will see it.
+			if rec.header.Get("Content-Type") == "" && rec.body.Len() > 0 {
+				rec.header.Set("Content-Type", http.DetectContentType(rec.body.Bytes()))
+			}
+
+			err = openapi3filter.ValidateResponse(r.Context(), &openapi3filter.ResponseValidationInput{
+				RequestValidationInput: input,
+				Status:                 rec.status,
+				Header:                 rec.header,
+				Body:                   io.NopCloser(bytes.NewReader(rec.body.Bytes())),
+				Options:                options,
+			})
+			if err != nil {
+				slog.ErrorContext(r.Context(), "response does not match api specification", "status", rec.status, "error", err)
+
+				problem.Code(w, r, "Response does not match API specification", "spec_violation", http.StatusInternalServerError)
+				return
+			}
+
+			for k, v := range rec.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(rec.status)
+			w.Write(rec.body.Bytes())
+		})
+	}, nil
+}
+
+func streaming(route *routers.Route) bool {
+	for status, response := range route.Operation.Responses.Map() {
+		if status == "101" {
+			return true
+		}
+		if response.Value != nil && response.Value.Content.Get("text/event-stream") != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+}
@@ -0,0 +1,122 @@
+package openapi
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidator(t *testing.T) {
+	doc, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validator, err := Validator(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Use(validator)
+	r.Post("/api/user/register", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Authorization", "Bearer token")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("alice"))
+	})
+	r.Post("/api/user/login", func(w http.ResponseWriter, r *http.Request) {
+		// 418 is not documented for this operation.
+		w.WriteHeader(http.StatusTeapot)
+	})
+	r.Get("/api/user/balance", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("broken") != "" {
+			w.Write([]byte(`{"current":"many"}`))
+			return
+		}
+		w.Write([]byte(`{"current":500.5,"withdrawn":42}`))
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+		code   string
+	}{
+		{"valid request", http.MethodPost, "/api/user/register", `{"login":"alice","password":"secret"}`, http.StatusOK, ""},
+		{"missing field", http.MethodPost, "/api/user/register", `{"login":"alice"}`, http.StatusBadRequest, "invalid_request"},
+		{"wrong type", http.MethodPost, "/api/user/register", `{"login":"alice","password":1}`, http.StatusBadRequest, "invalid_request"},
+		{"malformed json", http.MethodPost, "/api/user/register", `{"login":`, http.StatusBadRequest, "invalid_request"},
+		{"undocumented status", http.MethodPost, "/api/user/login", `{"login":"alice","password":"secret"}`, http.StatusInternalServerError, "spec_violation"},
+		{"valid response", http.MethodGet, "/api/user/balance", "", http.StatusOK, ""},
+		{"invalid response", http.MethodGet, "/api/user/balance?broken=1", "", http.StatusInternalServerError, "spec_violation"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			req.Header.Set("Accept", "application/json")
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.code == "" {
+				return
+			}
+
+			var p struct {
+				Code string `json:"code"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+				t.Fatalf("failed to decode problem: %v", err)
+			}
+			if p.Code != tt.code {
+				t.Errorf("code = %q, want %q", p.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestCheckRoutes(t *testing.T) {
+	doc, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noop := func(http.ResponseWriter, *http.Request) {}
+
+	r := chi.NewRouter()
+	r.Get("/api/user/balance", noop)
+	r.Get("/api/undocumented", noop)
+
+	err = CheckRoutes(doc, r)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	diff := make(map[string]bool)
+	for _, d := range strings.Split(err.Error(), "; ") {
+		diff[d] = true
+	}
+
+	if !diff["undocumented route GET /api/undocumented"] {
+		t.Errorf("undocumented route not reported: %v", err)
+	}
+	if !diff["documented route is not registered: POST /api/user/register"] {
+		t.Errorf("missing route not reported: %v", err)
+	}
+	if diff["documented route is not registered: GET /api/user/balance"] {
+		t.Errorf("registered route reported: %v", err)
+	}
+}
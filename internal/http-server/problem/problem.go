@@ -0,0 +1,182 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/requestid"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/validation"
+	"log/slog"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ContentType = "application/problem+json"
+
+// Problem is an RFC 7807 error body extended with a stable machine-readable
+// code and the ID of the failed request.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type mapping struct {
+	status int
+	code   string
+	detail string
+}
+
+// known maps the storage sentinel errors to what clients are told about them.
+var known = []struct {
+	err error
+	mapping
+}{
+	{storage.ErrLoginAlreadyExists, mapping{http.StatusConflict, "login_taken", "User already exists"}},
+	{storage.ErrUserNotFound, mapping{http.StatusUnauthorized, "invalid_credentials", "Invalid login or password"}},
+	{storage.ErrIncorrectPassword, mapping{http.StatusUnauthorized, "invalid_credentials", "Invalid login or password"}},
+	{storage.ErrOrderAlreadyLoadedByAnotherUser, mapping{http.StatusConflict, "order_owned_by_another_user", "Order already loaded by another user"}},
+	{storage.ErrOrderNotFound, mapping{http.StatusNotFound, "order_not_found", "Order not found"}},
+	{storage.ErrNotEnoughBalance, mapping{http.StatusPaymentRequired, "insufficient_balance", "Not enough balance"}},
+	{storage.ErrInvalidSum, mapping{http.StatusUnprocessableEntity, "invalid_sum", "Withdrawal sum must be positive"}},
+	{storage.ErrTooManyRequests, mapping{http.StatusTooManyRequests, "too_many_requests", "Too many requests"}},
+	{storage.ErrMerchantNotFound, mapping{http.StatusNotFound, "merchant_not_found", "Merchant not found"}},
+	{storage.ErrMerchantAlreadyExists, mapping{http.StatusConflict, "merchant_exists", "Merchant already exists"}},
+	{storage.ErrWebhookSecretRequired, mapping{http.StatusBadRequest, "webhook_secret_required", "A webhook URL needs a webhook secret"}},
+	{storage.ErrWebhookNotFound, mapping{http.StatusNotFound, "webhook_not_found", "Webhook not found"}},
+	{storage.ErrLoginLocked, mapping{http.StatusTooManyRequests, "login_locked", "Too many failed login attempts, try again later"}},
+	{storage.ErrNoLoginFailures, mapping{http.StatusNotFound, "login_not_locked", "No failed login attempts for this login"}},
+	{storage.ErrInvalidResetToken, mapping{http.StatusBadRequest, "invalid_reset_token", "Password reset token is invalid or expired"}},
+	{storage.ErrSessionRevoked, mapping{http.StatusUnauthorized, "session_revoked", "Session has been revoked, log in again"}},
+	{storage.ErrMFANotEnrolled, mapping{http.StatusNotFound, "mfa_not_enrolled", "Two-factor authentication is not set up"}},
+	{storage.ErrMFAAlreadyEnabled, mapping{http.StatusConflict, "mfa_already_enabled", "Two-factor authentication is already enabled"}},
+	{storage.ErrInvalidMFACode, mapping{http.StatusUnauthorized, "invalid_mfa_code", "Invalid two-factor code"}},
+	{storage.ErrMFARequired, mapping{http.StatusForbidden, "mfa_required", "Two-factor confirmation required, send a code in X-MFA-Code"}},
+	{storage.ErrAPIKeyNotFound, mapping{http.StatusNotFound, "api_key_not_found", "API key not found"}},
+	{storage.ErrInvalidAPIKey, mapping{http.StatusUnauthorized, "invalid_api_key", "API key is invalid or revoked"}},
+	{storage.ErrInsufficientScope, mapping{http.StatusForbidden, "insufficient_scope", "API key is not allowed to do this"}},
+	{storage.ErrAccountNotFound, mapping{http.StatusNotFound, "user_not_found", "User not found"}},
+	{storage.ErrAccountDisabled, mapping{http.StatusForbidden, "account_disabled", "Account has been disabled"}},
+	{storage.ErrOrderAlreadyProcessed, mapping{http.StatusConflict, "order_processed", "Order is already processed"}},
+	{storage.ErrInvalidOrderStatus, mapping{http.StatusBadRequest, "invalid_status", "Unknown order status"}},
+	{storage.ErrForeignOrder, mapping{http.StatusForbidden, "foreign_order", "Order belongs to another accrual system"}},
+}
+
+// codes are used for errors that do not come from storage.
+var codes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusPaymentRequired:       "payment_required",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+	http.StatusUnprocessableEntity:   "unprocessable_entity",
+	http.StatusTooManyRequests:       "too_many_requests",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusServiceUnavailable:    "service_unavailable",
+}
+
+// Error replies with the given detail and status, like http.Error.
+func Error(w http.ResponseWriter, r *http.Request, detail string, status int) {
+	code, ok := codes[status]
+	if !ok {
+		code = strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	}
+
+	write(w, r, status, code, detail)
+}
+
+// Code replies with an explicit error code.
+func Code(w http.ResponseWriter, r *http.Request, detail, code string, status int) {
+	write(w, r, status, code, detail)
+}
+
+// FromError replies with the status and code mapped to a storage error.
+// Anything unknown is logged and reported as an internal error without
+// revealing its message. Password policy violations carry their reason and
+// lockouts tell when to retry.
+func FromError(w http.ResponseWriter, r *http.Request, err error) {
+	var locked *storage.LockedError
+	if errors.As(err, &locked) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(locked.Until).Seconds()))))
+	}
+
+	var weak *validation.PasswordError
+	if errors.As(err, &weak) {
+		write(w, r, http.StatusBadRequest, "weak_password", weak.Reason)
+		return
+	}
+
+	for _, k := range known {
+		if errors.Is(err, k.err) {
+			write(w, r, k.status, k.code, k.detail)
+			return
+		}
+	}
+
+	Internal(w, r, err)
+}
+
+// Internal logs err and replies with a generic 500.
+func Internal(w http.ResponseWriter, r *http.Request, err error) {
+	slog.ErrorContext(r.Context(), "internal error", "error", err)
+
+	write(w, r, http.StatusInternalServerError, codes[http.StatusInternalServerError], "Internal server error")
+}
+
+func write(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	if status == http.StatusNoContent || status < http.StatusBadRequest {
+		w.WriteHeader(status)
+		return
+	}
+
+	id := requestid.Get(r.Context())
+
+	if !wantsJSON(r) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(status)
+		w.Write([]byte(detail + "\n"))
+		return
+	}
+
+	body, _ := json.Marshal(Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: id,
+	})
+
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// wantsJSON keeps the plain text errors for legacy clients and switches to
+// problem+json when the client asks for JSON.
+func wantsJSON(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if mediaType == ContentType || mediaType == "application/json" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,14 +1,42 @@
 package server
 
 import (
+	"context"
+	"fmt"
+	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/go-chi/chi/v5"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/apikey"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/audit"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/config"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/events"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/grpc-server/rpc"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/health"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/handlers"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/handlers/admin"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/handlers/balance"
+	healthhandlers "github.com/nglmq/gofermart-loyalty-programm/internal/http-server/handlers/health"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/handlers/merchants"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/handlers/orders"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/handlers/stream"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/openapi"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/logging"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/metrics"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/clientip"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/logger"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/ratelimit"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/requestid"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/session"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/notify"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/oidc"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/outbox"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/tracing"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/validation"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/webhooks"
 	"log/slog"
+	"net"
 	"net/http"
 	"time"
 )
@@ -16,42 +44,221 @@ import (
 func Start() (http.Handler, error) {
 	config.ParseFlags()
 
+	if err := logging.Setup(config.LogFormat, config.LogLevel); err != nil {
+		return nil, err
+	}
+
+	if err := tracing.Setup(context.Background(), config.TraceExporter); err != nil {
+		return nil, err
+	}
+
 	storage, err := postgres.New()
 	if err != nil {
-		slog.Error("failed to init db")
 		return nil, err
 	}
 
-	ticker := time.NewTicker(1 * time.Second)
-	quit := make(chan struct{})
+	broker := events.NewBroker()
+	go storage.ListenEvents(context.Background(), broker.Dispatch)
+
+	go webhooks.NewDispatcher(storage).Run(context.Background(), time.Second)
+	go audit.Chain(context.Background(), storage, time.Second)
+
+	for _, sink := range config.EventSinks {
+		publisher, err := outbox.NewPublisher(sink)
+		if err != nil {
+			return nil, err
+		}
+		go outbox.Run(context.Background(), storage, publisher, time.Second)
+	}
+
+	go orders.Poll(context.Background(), storage, config.AccrualPollInterval)
+
+	doc, err := openapi.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var limitStore ratelimit.Store
+	switch config.RateLimitStore {
+	case "memory":
+		limitStore = ratelimit.NewMemoryStore(ratelimit.MemoryCapacity)
+	case "postgres":
+		limitStore = ratelimit.NewPostgresStore(storage, time.Hour)
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", config.RateLimitStore)
+	}
+
+	limiter, err := ratelimit.New(limitStore, config.RateLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := session.NewAPIKeys(storage, limiter, config.APIKeyRateLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	clients, err := clientip.NewResolver(config.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validation.SetupPasswordHasher(config.PasswordHasher); err != nil {
+		return nil, err
+	}
+
+	if err := validation.SetupPasswordPolicy(config.PasswordMinLength, config.PasswordMaxLength, config.BreachedPasswords); err != nil {
+		return nil, err
+	}
+
+	notifier, err := notify.New(config.PasswordNotifier)
+	if err != nil {
+		return nil, err
+	}
+
+	var oidcProvider handlers.OIDCProvider
+	if config.OIDCIssuer != "" {
+		oidcProvider = oidc.New(config.OIDCIssuer, config.OIDCClientID, config.OIDCClientSecret, config.OIDCRedirectURL)
+	}
+
+	r, err := newRouter(doc, storage, broker, limiter, keys, clients.Middleware, notifier, oidcProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := openapi.CheckRoutes(doc, r); err != nil {
+		if config.OpenAPIValidate {
+			return nil, err
+		}
+		slog.Warn("router and openapi document differ", "error", err)
+	}
+
+	grpcServer := rpc.NewServer(storage, keys, limiter, clients, config.MFAWithdrawThreshold)
+	if config.GRPCAddr == "" {
+		return rpc.Handler(grpcServer, r), nil
+	}
+
+	lis, err := net.Listen("tcp", config.GRPCAddr)
+	if err != nil {
+		return nil, err
+	}
 	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				err := orders.ActualiseOrderData(storage)
-				if err != nil {
-					slog.Error("failed to actualise order data main goroutine", err)
-					return
-				}
-			case <-quit:
-				ticker.Stop()
-				return
-			}
+		if err := grpcServer.Serve(lis); err != nil {
+			slog.Error("grpc server stopped", "error", err)
 		}
 	}()
 
+	return r, nil
+}
+
+// newRouter registers the HTTP routes. It only wires handlers to their
+// dependencies, so it can be built without a database to compare the routes
+// with the OpenAPI document.
+func newRouter(
+	doc *openapi3.T,
+	storage *postgres.Storage,
+	broker *events.Broker,
+	limiter *ratelimit.Limiter,
+	keys *session.APIKeys,
+	clientIP func(http.Handler) http.Handler,
+	notifier notify.Notifier,
+	oidcProvider handlers.OIDCProvider,
+) (*chi.Mux, error) {
+	spec, err := openapi.SpecHandle(doc)
+	if err != nil {
+		return nil, err
+	}
+
 	r := chi.NewRouter()
 
+	r.Use(tracing.Middleware)
+	r.Use(requestid.RequestID)
+	r.Use(clientIP)
+	r.Use(audit.Middleware)
+	r.Use(metrics.Middleware)
 	r.Use(logger.RequestLogger)
+	if config.OpenAPIValidate {
+		validator, err := openapi.Validator(doc)
+		if err != nil {
+			return nil, err
+		}
+		r.Use(validator)
+	}
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		problem.Error(w, r, "Not found", http.StatusNotFound)
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		problem.Error(w, r, "Method is not allowed", http.StatusMethodNotAllowed)
+	})
 	r.Route("/api/user/", func(r chi.Router) {
-		r.Post("/register", handlers.RegistrationHandle(storage))
-		r.Post("/login", handlers.LoginHandle(storage))
-		r.Post("/orders", orders.LoadOrderHandle(storage))
-		r.Post("/balance/withdraw", balance.RequestWithdrawHandle(storage))
-		r.Get("/orders", orders.GetOrdersHandle(storage))
-		r.Get("/balance", balance.CheckBalanceHandle(storage))
-		r.Get("/withdrawals", balance.GetWithdrawalsHandle(storage))
+		r.Group(func(r chi.Router) {
+			r.Use(limiter.Limit(ratelimit.GroupAuth))
+			r.Post("/register", handlers.RegistrationHandle(storage))
+			r.Post("/login", handlers.LoginHandle(storage))
+			r.Post("/login/2fa", handlers.VerifyLoginHandle(storage))
+			r.Get("/oidc/login", handlers.OIDCLoginHandle(oidcProvider))
+			r.Get("/oidc/callback", handlers.OIDCCallbackHandle(oidcProvider, storage, config.OIDCLinkExisting))
+			r.Post("/password/forgot", handlers.RequestPasswordResetHandle(storage, notifier, config.PasswordResetTTL))
+			r.Post("/password/reset", handlers.ResetPasswordHandle(storage))
+			r.Group(func(r chi.Router) {
+				r.Use(session.Current(storage))
+				r.Post("/password", handlers.ChangePasswordHandle(storage))
+				r.Post("/2fa", handlers.EnrollMFAHandle(storage))
+				r.Post("/2fa/verify", handlers.EnableMFAHandle(storage))
+				r.Delete("/2fa", handlers.DisableMFAHandle(storage))
+			})
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(session.Current(storage))
+			r.With(keys.Allow(apikey.OrdersWrite), limiter.Limit(ratelimit.GroupWrite)).Post("/orders", orders.LoadOrderHandle(storage))
+			r.With(keys.Allow(apikey.BalanceWithdraw), limiter.Limit(ratelimit.GroupWithdraw)).Post("/balance/withdraw", balance.RequestWithdrawHandle(storage, storage, config.MFAWithdrawThreshold))
+			r.With(limiter.Limit(ratelimit.GroupWrite)).Post("/api-keys", handlers.CreateAPIKeyHandle(storage, keys.DefaultLimit()))
+			r.With(limiter.Limit(ratelimit.GroupWrite)).Delete("/api-keys/{id}", handlers.RevokeAPIKeyHandle(storage))
+			r.Group(func(r chi.Router) {
+				r.Use(limiter.Limit(ratelimit.GroupRead))
+				r.With(keys.Allow(apikey.OrdersRead)).Get("/orders", orders.GetOrdersHandle(storage))
+				r.With(keys.Allow(apikey.OrdersRead)).Get("/orders/{number}", orders.GetOrderHandle(storage))
+				r.With(keys.Allow(apikey.BalanceRead)).Get("/balance", balance.CheckBalanceHandle(storage))
+				r.With(keys.Allow(apikey.BalanceRead)).Get("/withdrawals", balance.GetWithdrawalsHandle(storage))
+				r.Get("/api-keys", handlers.GetAPIKeysHandle(storage))
+				r.Get("/events", stream.EventsHandle(broker, storage))
+				r.Get("/ws", stream.WebSocketHandle(broker, storage))
+			})
+		})
+	})
+	r.Post("/api/accrual/webhook", orders.AccrualWebhookHandle(storage))
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Use(session.Current(storage))
+		r.Use(middleware.Staff(storage))
+
+		r.Get("/users/{login}", admin.GetUserHandle(storage))
+		r.Get("/users/{login}/orders", admin.GetUserOrdersHandle(storage))
+		r.Get("/users/{login}/withdrawals", admin.GetUserWithdrawalsHandle(storage))
+		r.Post("/users/{login}/balance", admin.AdjustBalanceHandle(storage))
+		r.Post("/users/{login}/unlock", admin.UnlockUserHandle(storage))
+		r.Post("/orders/{number}/recheck", orders.RecheckOrderHandle(storage))
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequireRole(postgres.RoleAdmin))
+			r.Put("/users/{login}/role", admin.SetUserRoleHandle(storage))
+			r.Post("/users/{login}/disable", admin.DisableUserHandle(storage))
+			r.Post("/users/{login}/enable", admin.EnableUserHandle(storage))
+			r.Post("/merchants", merchants.CreateMerchantHandle(storage))
+			r.Get("/merchants", merchants.GetMerchantsHandle(storage))
+			r.Get("/merchants/latency", merchants.GetAccrualLatencyHandle(storage))
+			r.Get("/merchants/{id}", merchants.GetMerchantHandle(storage))
+			r.Put("/merchants/{id}", merchants.UpdateMerchantHandle(storage))
+			r.Delete("/merchants/{id}", merchants.DeleteMerchantHandle(storage))
+			r.Get("/webhooks/dead", merchants.GetDeadWebhooksHandle(storage))
+			r.Post("/webhooks/{id}/replay", merchants.ReplayWebhookHandle(storage))
+			r.Get("/events", admin.GetEventsHandle(storage))
+			r.Get("/audit", admin.GetAuditHandle(storage))
+		})
 	})
+	r.Get("/api/openapi.json", spec)
+	r.Get("/api/docs", openapi.DocsHandle())
+	r.Method(http.MethodGet, "/metrics", metrics.Handler())
+	r.Get("/healthz", healthhandlers.LiveHandle())
+	r.Get("/readyz", healthhandlers.ReadyHandle(health.NewChecker(storage, postgres.SchemaVersion, config.AccrualSystemAddress)))
 
 	return r, nil
 }
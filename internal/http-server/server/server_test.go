@@ -0,0 +1,42 @@
+package server
+
+import (
+	"github.com/nglmq/gofermart-loyalty-programm/internal/events"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/openapi"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/clientip"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/ratelimit"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/session"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/notify"
+	"testing"
+)
+
+func TestRoutesMatchOpenAPI(t *testing.T) {
+	doc, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limiter, err := ratelimit.New(ratelimit.NewMemoryStore(ratelimit.MemoryCapacity), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := session.NewAPIKeys(nil, limiter, "600/1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clients, err := clientip.NewResolver(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := newRouter(doc, nil, events.NewBroker(), limiter, keys, clients.Middleware, notify.LogNotifier{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := openapi.CheckRoutes(doc, r); err != nil {
+		t.Fatal(err)
+	}
+}
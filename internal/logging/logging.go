@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/requestid"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// redacted lists attribute keys whose values never reach the logs.
+var redacted = map[string]bool{
+	"password":      true,
+	"token":         true,
+	"authorization": true,
+	"credentials":   true,
+	"secret":        true,
+}
+
+type ctxKey struct{}
+
+// Setup makes slog's default logger, and through it the log package, write
+// records in the given format ("text" or "json") at or above level.
+func Setup(format, level string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl, ReplaceAttr: redact}
+
+	var handler slog.Handler
+	switch format {
+	case "", "text":
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	default:
+		return fmt.Errorf("invalid log format %q", format)
+	}
+
+	slog.SetDefault(slog.New(contextHandler{handler}))
+
+	return nil
+}
+
+// With returns a context whose log records carry the given key/value pairs.
+func With(ctx context.Context, args ...any) context.Context {
+	attrs, _ := ctx.Value(ctxKey{}).([]slog.Attr)
+	attrs = append(attrs[:len(attrs):len(attrs)], slog.Group("", args...).Value.Group()...)
+
+	return context.WithValue(ctx, ctxKey{}, attrs)
+}
+
+// contextHandler adds the request ID, the matched route, the trace ID and the
+// attributes stored with With to records logged with a context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestid.Get(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+		r.AddAttrs(slog.String("route", rctx.RoutePattern()))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	if attrs, ok := ctx.Value(ctxKey{}).([]slog.Attr); ok {
+		r.AddAttrs(attrs...)
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+func redact(_ []string, a slog.Attr) slog.Attr {
+	if redacted[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, "[REDACTED]")
+	}
+
+	return a
+}
@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"database/sql"
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const namespace = "gophermart"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route and status.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	grpcRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "grpc_requests_total",
+		Help:      "gRPC calls by method and status code.",
+	}, []string{"method", "code"})
+
+	grpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "grpc_request_duration_seconds",
+		Help:      "gRPC call latency by method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	accrualDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "accrual_request_duration_seconds",
+		Help:      "Latency of order requests to accrual systems.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"merchant", "status"})
+
+	accrualThrottled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "accrual_throttled_total",
+		Help:      "429 responses received from accrual systems.",
+	}, []string{"merchant"})
+
+	accrualBacklog = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "accrual_backlog_orders",
+		Help:      "Orders waiting for a final accrual status at the last poll.",
+	})
+
+	pointsCredited = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "points_credited_total",
+		Help:      "Points credited to users for processed orders.",
+	})
+
+	pointsWithdrawn = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "points_withdrawn_total",
+		Help:      "Points withdrawn by users.",
+	})
+)
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// RegisterDB exports the connection pool statistics of db as go_sql_* with
+// the label db_name="gophermart".
+func RegisterDB(db *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, namespace))
+}
+
+// Middleware counts requests and measures their latency. Routes are labelled
+// by their chi pattern, so path parameters do not multiply the series.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		labels := prometheus.Labels{"method": r.Method, "route": route, "status": strconv.Itoa(status)}
+		httpRequests.With(labels).Inc()
+		httpDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// ObserveGRPCRequest records a gRPC call to method that ended with code.
+func ObserveGRPCRequest(method, code string, d time.Duration) {
+	grpcRequests.WithLabelValues(method, code).Inc()
+	grpcDuration.WithLabelValues(method, code).Observe(d.Seconds())
+}
+
+// ObserveAccrualRequest records a request to a merchant's accrual system.
+// status is the HTTP status, or "error" if no response was received.
+func ObserveAccrualRequest(merchant, status string, d time.Duration) {
+	accrualDuration.WithLabelValues(merchantLabel(merchant), status).Observe(d.Seconds())
+	if status == strconv.Itoa(http.StatusTooManyRequests) {
+		accrualThrottled.WithLabelValues(merchantLabel(merchant)).Inc()
+	}
+}
+
+func SetAccrualBacklog(n int) {
+	accrualBacklog.Set(float64(n))
+}
+
+func AddPointsCredited(points float64) {
+	pointsCredited.Add(points)
+}
+
+func AddPointsWithdrawn(points float64) {
+	pointsWithdrawn.Add(points)
+}
+
+// merchantLabel names orders without a merchant after the default accrual system.
+func merchantLabel(name string) string {
+	if name == "" {
+		return "default"
+	}
+
+	return name
+}
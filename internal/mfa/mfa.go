@@ -0,0 +1,162 @@
+package mfa
+
+import (
+	"context"
+	"errors"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"log/slog"
+	"time"
+)
+
+type Getter interface {
+	GetMFA(ctx context.Context, login string) (postgres.MFA, error)
+}
+
+type Store interface {
+	Getter
+	UseMFAStep(ctx context.Context, login string, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, login, hash string) (bool, error)
+}
+
+// Guard counts wrong codes like failed logins, so six digits cannot be
+// guessed.
+type Guard interface {
+	LoginLockedUntil(ctx context.Context, login, ip string) (time.Time, error)
+	RecordLoginFailure(ctx context.Context, login, ip string) (time.Time, error)
+}
+
+type GuardedStore interface {
+	Store
+	Guard
+}
+
+// Enabled reports whether the user has confirmed a TOTP enrollment.
+func Enabled(ctx context.Context, s Getter, login string) (bool, error) {
+	m, err := s.GetMFA(ctx, login)
+	if errors.Is(err, storage.ErrMFANotEnrolled) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return m.Enabled, nil
+}
+
+// Verify accepts a current TOTP code, each at most once, or an unused
+// recovery code of a user with 2FA enabled.
+func Verify(ctx context.Context, s Store, login, code string) error {
+	m, err := s.GetMFA(ctx, login)
+	if err != nil {
+		return err
+	}
+	if !m.Enabled {
+		return storage.ErrMFANotEnrolled
+	}
+
+	if step, ok := Validate(m.Secret, code, time.Now()); ok {
+		used, err := s.UseMFAStep(ctx, login, step)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return storage.ErrInvalidMFACode
+		}
+
+		return nil
+	}
+
+	used, err := s.UseRecoveryCode(ctx, login, HashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return storage.ErrInvalidMFACode
+	}
+
+	slog.InfoContext(ctx, "recovery code used", "user", login)
+
+	return nil
+}
+
+// Confirm verifies a code from ip, refusing locked out clients and counting
+// wrong codes against the login and the IP.
+func Confirm(ctx context.Context, s GuardedStore, login, code, ip string) error {
+	until, err := s.LoginLockedUntil(ctx, login, ip)
+	if err != nil {
+		return err
+	}
+	if !until.IsZero() {
+		return &storage.LockedError{Until: until}
+	}
+
+	err = Verify(ctx, s, login, code)
+	if errors.Is(err, storage.ErrInvalidMFACode) {
+		if _, recordErr := s.RecordLoginFailure(ctx, login, ip); recordErr != nil {
+			return recordErr
+		}
+	}
+
+	return err
+}
+
+type Enroller interface {
+	StartMFAEnrollment(ctx context.Context, login, secret string, recoveryHashes []string) error
+	GetMFA(ctx context.Context, login string) (postgres.MFA, error)
+	EnableMFA(ctx context.Context, login string, step int64) (bool, error)
+}
+
+// Enrollment is what the user needs to set up an authenticator app. The
+// recovery codes are shown once.
+type Enrollment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"otpauth_uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// Enroll starts a TOTP enrollment, which takes effect once Enable confirms
+// the user's app produces valid codes.
+func Enroll(ctx context.Context, s Enroller, login string) (Enrollment, error) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		return Enrollment{}, err
+	}
+
+	codes, hashes, err := GenerateRecoveryCodes()
+	if err != nil {
+		return Enrollment{}, err
+	}
+
+	if err := s.StartMFAEnrollment(ctx, login, secret, hashes); err != nil {
+		return Enrollment{}, err
+	}
+
+	return Enrollment{Secret: secret, URI: URI(login, secret), RecoveryCodes: codes}, nil
+}
+
+// Enable confirms a pending enrollment with a TOTP code.
+func Enable(ctx context.Context, s Enroller, login, code string) error {
+	m, err := s.GetMFA(ctx, login)
+	if err != nil {
+		return err
+	}
+	if m.Enabled {
+		return storage.ErrMFAAlreadyEnabled
+	}
+
+	step, ok := Validate(m.Secret, code, time.Now())
+	if !ok {
+		return storage.ErrInvalidMFACode
+	}
+
+	enabled, err := s.EnableMFA(ctx, login, step)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return storage.ErrInvalidMFACode
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package mfa
+
+import (
+	"context"
+	"errors"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"testing"
+	"time"
+)
+
+const testLogin = "alice"
+
+// fakeStore keeps one user's enrollment the way the postgres storage does:
+// a step is accepted only if it is later than the last one used.
+type fakeStore struct {
+	mfa      postgres.MFA
+	lastStep int64
+	recovery map[string]bool
+
+	// guard
+	limit    int
+	failures int
+	until    time.Time
+}
+
+func newFakeStore(t *testing.T) *fakeStore {
+	t.Helper()
+
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &fakeStore{
+		mfa:      postgres.MFA{Secret: secret, Enabled: true},
+		recovery: map[string]bool{HashRecoveryCode("k7pq-3mzx"): true},
+		limit:    3,
+	}
+}
+
+func (s *fakeStore) GetMFA(context.Context, string) (postgres.MFA, error) {
+	return s.mfa, nil
+}
+
+func (s *fakeStore) UseMFAStep(_ context.Context, _ string, step int64) (bool, error) {
+	if step <= s.lastStep {
+		return false, nil
+	}
+	s.lastStep = step
+
+	return true, nil
+}
+
+func (s *fakeStore) UseRecoveryCode(_ context.Context, _, hash string) (bool, error) {
+	if !s.recovery[hash] {
+		return false, nil
+	}
+	delete(s.recovery, hash)
+
+	return true, nil
+}
+
+func (s *fakeStore) LoginLockedUntil(context.Context, string, string) (time.Time, error) {
+	return s.until, nil
+}
+
+func (s *fakeStore) RecordLoginFailure(context.Context, string, string) (time.Time, error) {
+	s.failures++
+	if s.failures >= s.limit {
+		s.until = time.Now().Add(time.Minute)
+	}
+
+	return s.until, nil
+}
+
+// currentCode returns the code an authenticator app shows for secret now.
+func currentCode(t *testing.T, secret string) string {
+	t.Helper()
+
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return codeAt(key, time.Now().Unix()/period)
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(s *fakeStore) string
+		wantErr error
+	}{
+		{
+			name:    "current code",
+			prepare: func(s *fakeStore) string { return currentCode(t, s.mfa.Secret) },
+		},
+		{
+			name: "replayed code",
+			prepare: func(s *fakeStore) string {
+				code := currentCode(t, s.mfa.Secret)
+				if err := Verify(context.Background(), s, testLogin, code); err != nil {
+					t.Fatal(err)
+				}
+				return code
+			},
+			wantErr: storage.ErrInvalidMFACode,
+		},
+		{
+			name: "code of an earlier step than the last used",
+			prepare: func(s *fakeStore) string {
+				s.lastStep = time.Now().Unix()/period + skew
+				return currentCode(t, s.mfa.Secret)
+			},
+			wantErr: storage.ErrInvalidMFACode,
+		},
+		{
+			name:    "recovery code",
+			prepare: func(s *fakeStore) string { return "K7PQ 3MZX" },
+		},
+		{
+			name: "spent recovery code",
+			prepare: func(s *fakeStore) string {
+				if err := Verify(context.Background(), s, testLogin, "k7pq-3mzx"); err != nil {
+					t.Fatal(err)
+				}
+				return "k7pq-3mzx"
+			},
+			wantErr: storage.ErrInvalidMFACode,
+		},
+		{
+			name:    "wrong code",
+			prepare: func(s *fakeStore) string { return "not-a-code" },
+			wantErr: storage.ErrInvalidMFACode,
+		},
+		{
+			name: "not enabled",
+			prepare: func(s *fakeStore) string {
+				s.mfa.Enabled = false
+				return currentCode(t, s.mfa.Secret)
+			},
+			wantErr: storage.ErrMFANotEnrolled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeStore(t)
+			code := tt.prepare(s)
+
+			if err := Verify(context.Background(), s, testLogin, code); !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfirmLockout(t *testing.T) {
+	s := newFakeStore(t)
+	ctx := context.Background()
+
+	for i := 1; i < s.limit; i++ {
+		if err := Confirm(ctx, s, testLogin, "000000", "203.0.113.1"); !errors.Is(err, storage.ErrInvalidMFACode) {
+			t.Fatalf("attempt %d: err = %v, want %v", i, err, storage.ErrInvalidMFACode)
+		}
+	}
+	if s.failures != s.limit-1 {
+		t.Fatalf("failures = %d, want %d", s.failures, s.limit-1)
+	}
+
+	// A correct code is not counted as a failure.
+	if err := Confirm(ctx, s, testLogin, currentCode(t, s.mfa.Secret), "203.0.113.1"); err != nil {
+		t.Fatal(err)
+	}
+	if s.failures != s.limit-1 {
+		t.Fatalf("failures after a correct code = %d, want %d", s.failures, s.limit-1)
+	}
+
+	// The last allowed wrong code locks the login out.
+	if err := Confirm(ctx, s, testLogin, "000000", "203.0.113.1"); !errors.Is(err, storage.ErrInvalidMFACode) {
+		t.Fatalf("err = %v, want %v", err, storage.ErrInvalidMFACode)
+	}
+
+	// Even the recovery code is refused while locked out, and nothing more
+	// is counted.
+	err := Confirm(ctx, s, testLogin, "k7pq-3mzx", "203.0.113.1")
+	var locked *storage.LockedError
+	if !errors.As(err, &locked) || !locked.Until.Equal(s.until) {
+		t.Fatalf("err = %v, want a lockout until %v", err, s.until)
+	}
+	if s.failures != s.limit {
+		t.Errorf("failures = %d, want %d", s.failures, s.limit)
+	}
+	if !s.recovery[HashRecoveryCode("k7pq-3mzx")] {
+		t.Error("recovery code was spent while locked out")
+	}
+}
@@ -0,0 +1,49 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// RecoveryCodes is how many single-use codes a user gets on enrollment.
+const RecoveryCodes = 10
+
+const recoveryAlphabet = "abcdefghijklmnopqrstuvwxyz234567"
+
+// GenerateRecoveryCodes returns codes such as "k7pq-3mzx" together with the
+// hashes to store.
+func GenerateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, RecoveryCodes)
+	hashes := make([]string, RecoveryCodes)
+
+	for i := range codes {
+		raw := make([]byte, 8)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+
+		var b strings.Builder
+		for j, c := range raw {
+			if j == 4 {
+				b.WriteByte('-')
+			}
+			b.WriteByte(recoveryAlphabet[c&31])
+		}
+
+		codes[i] = b.String()
+		hashes[i] = HashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode hashes a recovery code ignoring case, spaces and dashes.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,68 @@
+package mfa
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of the RFC 6238 test vectors, base32 encoded.
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+// The RFC lists 8-digit codes; six digits are their last six.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},          // 94287082
+	{1111111109, "081804"},  // 07081804
+	{1111111111, "050471"},  // 14050471
+	{1234567890, "005924"},  // 89005924
+	{2000000000, "279037"},  // 69279037
+	{20000000000, "353130"}, // 65353130
+}
+
+func TestCodeAt(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	for _, v := range rfcVectors {
+		if got := codeAt(key, v.unix/period); got != v.code {
+			t.Errorf("codeAt(T=%d) = %s, want %s", v.unix, got, v.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, v := range rfcVectors {
+		step, ok := Validate(rfcSecret, v.code, time.Unix(v.unix, 0))
+		if !ok || step != v.unix/period {
+			t.Errorf("Validate(%s, T=%d) = %d, %v, want %d, true", v.code, v.unix, step, ok, v.unix/period)
+		}
+	}
+
+	now := time.Unix(1111111111, 0)
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		now    time.Time
+		want   bool
+	}{
+		{"previous step", rfcSecret, "050471", now.Add(period * time.Second), true},
+		{"next step", rfcSecret, "050471", now.Add(-period * time.Second), true},
+		{"two steps late", rfcSecret, "050471", now.Add(2 * period * time.Second), false},
+		{"surrounding spaces", rfcSecret, " 050471 ", now, true},
+		{"lowercase secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "050471", now, true},
+		{"wrong code", rfcSecret, "050472", now, false},
+		{"too short", rfcSecret, "05047", now, false},
+		{"eight digits", rfcSecret, "14050471", now, false},
+		{"invalid secret", "not base32!", "050471", now, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := Validate(tt.secret, tt.code, tt.now); ok != tt.want {
+				t.Errorf("Validate = %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
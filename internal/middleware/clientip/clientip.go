@@ -0,0 +1,110 @@
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type ctxKey struct{}
+
+// Resolver finds the address of a client behind trusted proxies.
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// NewResolver returns a resolver honouring forwarding headers only from the
+// trusted proxies, given as CIDRs or single addresses.
+func NewResolver(trustedProxies []string) (*Resolver, error) {
+	var trusted []*net.IPNet
+
+	for _, proxy := range trustedProxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		trusted = append(trusted, network)
+	}
+
+	return &Resolver{trusted: trusted}, nil
+}
+
+// Middleware stores the address of the client in the request context.
+func (res *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := res.Resolve(r.RemoteAddr, r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Real-IP"))
+		next.ServeHTTP(w, r.WithContext(WithIP(r.Context(), ip)))
+	})
+}
+
+// WithIP returns a context carrying the client address ip.
+func WithIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, ip)
+}
+
+// Get returns the client address of the request the context belongs to.
+func Get(ctx context.Context) string {
+	ip, _ := ctx.Value(ctxKey{}).(string)
+	return ip
+}
+
+// Resolve returns the client address of a connection from remoteAddr that
+// carried the given X-Forwarded-For and X-Real-IP values. If remoteAddr is a
+// trusted proxy, the client is the rightmost X-Forwarded-For entry not added
+// by one of them, or X-Real-IP.
+func (res *Resolver) Resolve(remoteAddr, forwardedFor, realIP string) string {
+	ip := remoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	if !isTrusted(ip, res.trusted) {
+		return ip
+	}
+
+	if forwardedFor != "" {
+		hops := strings.Split(forwardedFor, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			ip = hop
+			if !isTrusted(hop, res.trusted) {
+				return hop
+			}
+		}
+		return ip
+	}
+
+	if realIP = strings.TrimSpace(realIP); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+
+	return ip
+}
+
+func isTrusted(ip string, trusted []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, network := range trusted {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
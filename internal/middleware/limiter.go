@@ -1,75 +0,0 @@
-package middleware
-
-import (
-	"golang.org/x/time/rate"
-	"net/http"
-	"sync"
-)
-
-// IPRateLimiter .
-type IPRateLimiter struct {
-	ips map[string]*rate.Limiter
-	mu  *sync.RWMutex
-	r   rate.Limit
-	b   int
-}
-
-// NewIPRateLimiter .
-func NewIPRateLimiter(r rate.Limit, b int) *IPRateLimiter {
-	i := &IPRateLimiter{
-		ips: make(map[string]*rate.Limiter),
-		mu:  &sync.RWMutex{},
-		r:   r,
-		b:   b,
-	}
-
-	return i
-}
-
-// AddIP creates a new rate limiter and adds it to the ips map,
-// using the IP address as the key
-func (i *IPRateLimiter) AddIP(ip string) *rate.Limiter {
-	i.mu.Lock()
-	defer i.mu.Unlock()
-
-	limiter := rate.NewLimiter(i.r, i.b)
-
-	i.ips[ip] = limiter
-
-	return limiter
-}
-
-// GetLimiter returns the rate limiter for the provided IP address if it exists.
-// Otherwise calls AddIP to add IP address to the map
-func (i *IPRateLimiter) GetLimiter(ip string) *rate.Limiter {
-	i.mu.Lock()
-	limiter, exists := i.ips[ip]
-
-	if !exists {
-		i.mu.Unlock()
-		return i.AddIP(ip)
-	}
-
-	i.mu.Unlock()
-
-	return limiter
-}
-
-func LimitMiddleware(next http.Handler) http.Handler {
-	var limiter = NewIPRateLimiter(1, 5)
-
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		limiter := limiter.GetLimiter(r.RemoteAddr)
-		if !limiter.Allow() {
-
-			w.Header().Set("Content-Type", "text/plain")
-			w.Header().Set("Retry-After", "60")
-			w.WriteHeader(http.StatusTooManyRequests)
-			w.Write([]byte(" No more than 5 requests per minute allowed"))
-
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
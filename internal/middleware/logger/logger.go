@@ -1,10 +1,13 @@
 package logger
 
 import (
+	"bufio"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/auth"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/logging"
+	"log/slog"
+	"net"
 	"net/http"
 	"time"
-
-	"go.uber.org/zap"
 )
 
 type (
@@ -20,6 +23,9 @@ type (
 )
 
 func (r *loggingResponseWriter) Write(b []byte) (int, error) {
+	if r.responseData.status == 0 {
+		r.responseData.status = http.StatusOK
+	}
 	size, err := r.ResponseWriter.Write(b)
 	r.responseData.size += size
 	return size, err
@@ -30,17 +36,24 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode
 }
 
-func RequestLogger(next http.Handler) http.Handler {
-	logger, err := zap.NewDevelopment()
-	if err != nil {
-		panic(err)
-	}
-	defer logger.Sync()
-
-	logFn := func(w http.ResponseWriter, r *http.Request) {
+// Unwrap lets http.ResponseController reach the underlying writer, which
+// streaming handlers need for flushing.
+func (r *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
 
-		sugar := *logger.Sugar()
+// Hijack hands the connection over to WebSocket handlers.
+func (r *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.responseData.status = http.StatusSwitchingProtocols
+	return http.NewResponseController(r.ResponseWriter).Hijack()
+}
 
+// RequestLogger logs every request once it is served. The authenticated user
+// is attached to the request context, so every record logged while handling
+// the request carries it. Only the path is logged: query strings may hold
+// tokens.
+func RequestLogger(next http.Handler) http.Handler {
+	logFn := func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
 		responseData := &responseData{
@@ -52,17 +65,20 @@ func RequestLogger(next http.Handler) http.Handler {
 			responseData:   responseData,
 		}
 
-		next.ServeHTTP(&lw, r)
-		duration := time.Since(start)
+		ctx := r.Context()
+		if login := auth.GetUserID(r.Header.Get("Authorization")); login != "" {
+			ctx = logging.With(ctx, "user", login)
+		}
 
-		if r.Method == http.MethodPost {
-			sugar.Infof("method: %s, url: %s, status: %d, duration: %s, size: %d",
-				r.Method, r.RequestURI, responseData.status, duration, responseData.size)
+		next.ServeHTTP(&lw, r.WithContext(ctx))
 
-		} else {
-			sugar.Infof("method: %s, url: %s, status: %d, size: %d, duration: %s",
-				r.Method, r.RequestURI, responseData.status, responseData.size, duration)
-		}
+		slog.InfoContext(ctx, "request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", responseData.status,
+			"size", responseData.size,
+			"duration", time.Since(start),
+		)
 	}
 	return http.HandlerFunc(logFn)
 }
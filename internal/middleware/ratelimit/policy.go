@@ -0,0 +1,147 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Route groups the router applies policies to.
+const (
+	GroupAuth     = "auth"
+	GroupRead     = "read"
+	GroupWrite    = "write"
+	GroupWithdraw = "withdraw"
+)
+
+// DefaultPolicies is used for groups the configuration does not mention.
+const DefaultPolicies = "auth=10/1m,read=20/1s,write=60/1m,withdraw=10/1m"
+
+// Policy is a token bucket: Burst requests at once, refilled at Rate
+// requests per second. A zero Rate disables limiting.
+type Policy struct {
+	Rate  float64
+	Burst int
+}
+
+// ParsePolicies reads a comma separated list of group=requests/period[:burst]
+// entries, e.g. "auth=5/1m,read=50/1s:100". Burst defaults to requests and
+// "group=off" disables the group's limit.
+func ParsePolicies(spec string) (map[string]Policy, error) {
+	policies := make(map[string]Policy)
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		group, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q", entry)
+		}
+
+		p, err := ParsePolicy(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit %q: %w", entry, err)
+		}
+
+		policies[group] = p
+	}
+
+	return policies, nil
+}
+
+// ParsePolicy reads a single requests/period[:burst] policy or "off".
+func ParsePolicy(value string) (Policy, error) {
+	if value == "off" {
+		return Policy{}, nil
+	}
+
+	value, burst, hasBurst := strings.Cut(value, ":")
+	count, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return Policy{}, errors.New("want requests/period")
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return Policy{}, errors.New("bad request count")
+	}
+
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Policy{}, errors.New("bad period")
+	}
+
+	p := Policy{Rate: float64(n) / d.Seconds(), Burst: n}
+	if hasBurst {
+		p.Burst, err = strconv.Atoi(burst)
+		if err != nil || p.Burst <= 0 {
+			return Policy{}, errors.New("bad burst")
+		}
+	}
+
+	return p, nil
+}
+
+func (p Policy) enabled() bool {
+	return p.Rate > 0 && p.Burst > 0
+}
+
+// Within reports whether p allows no more than limit: no higher rate and no
+// larger burst. Every policy is within a disabled limit.
+func (p Policy) Within(limit Policy) bool {
+	if !limit.enabled() {
+		return true
+	}
+
+	return p.enabled() && p.Rate <= limit.Rate && p.Burst <= limit.Burst
+}
+
+// window is how long an empty bucket takes to fill up. A bucket idle for
+// that long is indistinguishable from a new one.
+func (p Policy) window() time.Duration {
+	return time.Duration(float64(p.Burst) / p.Rate * float64(time.Second))
+}
+
+// take refills a bucket that held tokens elapsed ago and takes one token
+// from it if there is one.
+func (p Policy) take(tokens float64, elapsed time.Duration) (float64, bool) {
+	tokens = math.Min(float64(p.Burst), tokens+elapsed.Seconds()*p.Rate)
+	if tokens < 1 {
+		return tokens, false
+	}
+
+	return tokens - 1, true
+}
+
+// Result describes the state of a bucket after a request.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+func (p Policy) result(tokens float64, allowed bool) Result {
+	r := Result{
+		Allowed:   allowed,
+		Limit:     p.Burst,
+		Remaining: int(tokens),
+		Reset:     seconds((float64(p.Burst) - tokens) / p.Rate),
+	}
+	if !allowed {
+		r.RetryAfter = seconds((1 - tokens) / p.Rate)
+	}
+
+	return r
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePolicies(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    map[string]Policy
+		wantErr bool
+	}{
+		{spec: "", want: map[string]Policy{}},
+		{spec: "auth=10/1m", want: map[string]Policy{"auth": {Rate: 10.0 / 60, Burst: 10}}},
+		{spec: "read=50/1s:100", want: map[string]Policy{"read": {Rate: 50, Burst: 100}}},
+		{spec: " auth=5/1m , ,write=off", want: map[string]Policy{"auth": {Rate: 5.0 / 60, Burst: 5}, "write": {}}},
+		{spec: DefaultPolicies, want: map[string]Policy{
+			GroupAuth:     {Rate: 10.0 / 60, Burst: 10},
+			GroupRead:     {Rate: 20, Burst: 20},
+			GroupWrite:    {Rate: 1, Burst: 60},
+			GroupWithdraw: {Rate: 10.0 / 60, Burst: 10},
+		}},
+		{spec: "auth", wantErr: true},
+		{spec: "auth=10", wantErr: true},
+		{spec: "auth=0/1m", wantErr: true},
+		{spec: "auth=-1/1m", wantErr: true},
+		{spec: "auth=ten/1m", wantErr: true},
+		{spec: "auth=10/0s", wantErr: true},
+		{spec: "auth=10/minute", wantErr: true},
+		{spec: "auth=10/1m:0", wantErr: true},
+		{spec: "auth=10/1m:", wantErr: true},
+		{spec: "auth=10/1m,read", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParsePolicies(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParsePolicies(%q) = %v, want an error", tt.spec, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePolicies(%q) = %v, want %v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyTake(t *testing.T) {
+	p := Policy{Rate: 2, Burst: 4}
+
+	tests := []struct {
+		name        string
+		tokens      float64
+		elapsed     time.Duration
+		wantTokens  float64
+		wantAllowed bool
+	}{
+		{"full bucket", 4, 0, 3, true},
+		{"last token", 1, 0, 0, true},
+		{"empty bucket", 0, 0, 0, false},
+		{"partial refill is not enough", 0, 250 * time.Millisecond, 0.5, false},
+		{"refilled one token", 0, 500 * time.Millisecond, 0, true},
+		{"refill stops at burst", 1, time.Hour, 3, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, allowed := p.take(tt.tokens, tt.elapsed)
+			if math.Abs(tokens-tt.wantTokens) > 1e-9 || allowed != tt.wantAllowed {
+				t.Errorf("take(%v, %v) = %v, %v, want %v, %v", tt.tokens, tt.elapsed, tokens, allowed, tt.wantTokens, tt.wantAllowed)
+			}
+		})
+	}
+}
+
+func TestPolicyWithin(t *testing.T) {
+	limit := Policy{Rate: 10, Burst: 600}
+
+	tests := []struct {
+		name   string
+		policy Policy
+		limit  Policy
+		want   bool
+	}{
+		{"same", limit, limit, true},
+		{"stricter", Policy{Rate: 1, Burst: 10}, limit, true},
+		{"faster", Policy{Rate: 100, Burst: 600}, limit, false},
+		{"larger burst", Policy{Rate: 10, Burst: 1000}, limit, false},
+		{"disabled", Policy{}, limit, false},
+		{"anything within a disabled limit", Policy{Rate: 1e6, Burst: 1e6}, Policy{}, true},
+		{"disabled within a disabled limit", Policy{}, Policy{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Within(tt.limit); got != tt.want {
+				t.Errorf("%+v.Within(%+v) = %v, want %v", tt.policy, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
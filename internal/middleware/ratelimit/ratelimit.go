@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/auth"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/clientip"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Limiter applies the policy of a route group to every client.
+type Limiter struct {
+	store    Store
+	policies map[string]Policy
+}
+
+// New returns a limiter using policies from spec on top of DefaultPolicies.
+func New(store Store, spec string) (*Limiter, error) {
+	policies, err := ParsePolicies(DefaultPolicies)
+	if err != nil {
+		return nil, err
+	}
+
+	custom, err := ParsePolicies(spec)
+	if err != nil {
+		return nil, err
+	}
+	for group, p := range custom {
+		policies[group] = p
+	}
+
+	return &Limiter{store: store, policies: policies}, nil
+}
+
+// Limit rate limits the requests of a route group. Authenticated requests are
+// counted per user, the others per client IP as resolved by the clientip
+// middleware. If the store fails the request
+// is let through rather than taking the API down with it.
+func (l *Limiter) Limit(group string) func(http.Handler) http.Handler {
+	p := l.policies[group]
+
+	return func(next http.Handler) http.Handler {
+		if !p.enabled() {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := groupKey(group, auth.RequestLogin(r), clientip.Get(r.Context()))
+			if l.Allow(w, r, key, p) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// Allow takes a request of key from the bucket of policy p, setting the
+// RateLimit headers. Refused requests are answered with 429 and false is
+// returned. A disabled policy allows everything.
+func (l *Limiter) Allow(w http.ResponseWriter, r *http.Request, key string, p Policy) bool {
+	if !p.enabled() {
+		return true
+	}
+
+	res, err := l.store.Take(r.Context(), key, p)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "rate limiter failed", "error", err)
+		return true
+	}
+
+	h := w.Header()
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", p.Burst, ceil(p.window())))
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceil(res.Reset)))
+
+	if !res.Allowed {
+		h.Set("Retry-After", strconv.Itoa(ceil(res.RetryAfter)))
+		problem.Error(w, r, "Too many requests", http.StatusTooManyRequests)
+		return false
+	}
+
+	return true
+}
+
+// Take takes a request of key from the bucket of policy p without writing a
+// response, for callers outside HTTP. A disabled policy allows everything.
+func (l *Limiter) Take(ctx context.Context, key string, p Policy) (Result, error) {
+	if !p.enabled() {
+		return Result{Allowed: true}, nil
+	}
+
+	return l.store.Take(ctx, key, p)
+}
+
+// TakeGroup counts a request against the policy of a route group like Limit,
+// for callers outside HTTP: per user if login is set, per client IP if not.
+func (l *Limiter) TakeGroup(ctx context.Context, group, login, ip string) (Result, error) {
+	return l.Take(ctx, groupKey(group, login, ip), l.policies[group])
+}
+
+func groupKey(group, login, ip string) string {
+	if login != "" {
+		return group + ":user:" + login
+	}
+
+	return group + ":ip:" + ip
+}
+
+func ceil(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,117 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Store keeps the token buckets.
+type Store interface {
+	Take(ctx context.Context, key string, p Policy) (Result, error)
+}
+
+// MemoryCapacity bounds the buckets a MemoryStore holds.
+const MemoryCapacity = 100_000
+
+type bucket struct {
+	key     string
+	tokens  float64
+	updated time.Time
+	window  time.Duration
+}
+
+// MemoryStore keeps buckets in process, so every replica limits on its own.
+// It holds at most capacity buckets, dropping the least recently used, and
+// forgets buckets idle long enough to have refilled.
+type MemoryStore struct {
+	mu       sync.Mutex
+	capacity int
+	buckets  map[string]*list.Element
+	lru      *list.List
+}
+
+func NewMemoryStore(capacity int) *MemoryStore {
+	return &MemoryStore{
+		capacity: capacity,
+		buckets:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, p Policy) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.evict(now, s.capacity)
+
+	var b *bucket
+	if el, ok := s.buckets[key]; ok {
+		b = el.Value.(*bucket)
+		s.lru.MoveToFront(el)
+	} else {
+		s.evict(now, s.capacity-1)
+		b = &bucket{key: key, tokens: float64(p.Burst), updated: now}
+		s.buckets[key] = s.lru.PushFront(b)
+	}
+
+	tokens, allowed := p.take(b.tokens, now.Sub(b.updated))
+	b.tokens, b.updated, b.window = tokens, now, p.window()
+
+	return p.result(tokens, allowed), nil
+}
+
+// evict drops buckets from the idle end of the list while there are more
+// than limit or they have refilled.
+func (s *MemoryStore) evict(now time.Time, limit int) {
+	for el := s.lru.Back(); el != nil; el = s.lru.Back() {
+		b := el.Value.(*bucket)
+		if s.lru.Len() <= limit && now.Sub(b.updated) < b.window {
+			return
+		}
+
+		s.lru.Remove(el)
+		delete(s.buckets, b.key)
+	}
+}
+
+type RateLimitStorage interface {
+	TakeRateLimit(ctx context.Context, key string, rate float64, burst int) (tokens float64, allowed bool, err error)
+	DeleteIdleRateLimits(ctx context.Context, idle time.Duration) error
+}
+
+const cleanupInterval = time.Minute
+
+// PostgresStore shares buckets between replicas through the database.
+// Buckets idle for longer than idle are deleted about once a minute.
+type PostgresStore struct {
+	storage     RateLimitStorage
+	idle        time.Duration
+	lastCleanup atomic.Int64
+}
+
+func NewPostgresStore(storage RateLimitStorage, idle time.Duration) *PostgresStore {
+	return &PostgresStore{storage: storage, idle: idle}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, p Policy) (Result, error) {
+	tokens, allowed, err := s.storage.TakeRateLimit(ctx, key, p.Rate, p.Burst)
+	if err != nil {
+		return Result{}, err
+	}
+
+	now := time.Now().UnixNano()
+	if last := s.lastCleanup.Load(); now-last > int64(cleanupInterval) && s.lastCleanup.CompareAndSwap(last, now) {
+		go func() {
+			if err := s.storage.DeleteIdleRateLimits(context.Background(), s.idle); err != nil {
+				slog.Error("failed to delete idle rate limits", "error", err)
+			}
+		}()
+	}
+
+	return p.result(tokens, allowed), nil
+}
@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	s := NewMemoryStore(2)
+	p := Policy{Rate: 1.0 / 60, Burst: 2}
+	ctx := context.Background()
+
+	take := func(key string) Result {
+		t.Helper()
+		r, err := s.Take(ctx, key, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	take("a")
+	take("b")
+	take("a") // b is now the least recently used
+	take("c")
+
+	if len(s.buckets) != 2 || s.lru.Len() != 2 {
+		t.Fatalf("store holds %d buckets (%d in the list), want 2", len(s.buckets), s.lru.Len())
+	}
+	if _, ok := s.buckets["b"]; ok {
+		t.Error("b was kept, want it evicted")
+	}
+
+	// a spent its burst and is still limited; b starts over with a full bucket.
+	if r := take("a"); r.Allowed {
+		t.Error("a was allowed after spending its burst")
+	}
+	if r := take("b"); !r.Allowed || r.Remaining != p.Burst-1 {
+		t.Errorf("b = %+v, want a fresh bucket", r)
+	}
+}
+
+func TestMemoryStoreEvictsRefilledBuckets(t *testing.T) {
+	s := NewMemoryStore(MemoryCapacity)
+	ctx := context.Background()
+
+	fast := Policy{Rate: 1000, Burst: 1}
+	slow := Policy{Rate: 1.0 / 60, Burst: 1}
+
+	if _, err := s.Take(ctx, "fast", fast); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Take(ctx, "slow", slow); err != nil {
+		t.Fatal(err)
+	}
+
+	// The fast bucket refills in a millisecond, the slow one in a minute.
+	time.Sleep(10 * time.Millisecond)
+
+	if _, err := s.Take(ctx, "other", slow); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.buckets["fast"]; ok {
+		t.Error("refilled bucket was kept")
+	}
+	if _, ok := s.buckets["slow"]; !ok {
+		t.Error("bucket still refilling was evicted")
+	}
+}
@@ -0,0 +1,60 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+const Header = "X-Request-ID"
+
+type ctxKey struct{}
+
+// RequestID tags every request with an ID, reusing a sane X-Request-ID from
+// the client or a proxy, and echoes it in the response headers.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, id := With(r.Context(), r.Header.Get(Header))
+		w.Header().Set(Header, id)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// With tags ctx with id, or with a new ID if id is not sane, and returns the
+// ID used. It serves callers outside HTTP.
+func With(ctx context.Context, id string) (context.Context, string) {
+	if !valid(id) {
+		id = newID()
+	}
+
+	return context.WithValue(ctx, ctxKey{}, id), id
+}
+
+// Get returns the ID of the request the context belongs to.
+func Get(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+func valid(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+
+	return true
+}
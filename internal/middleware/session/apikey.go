@@ -0,0 +1,121 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/apikey"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/auth"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/logging"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/middleware/ratelimit"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type APIKeyUser interface {
+	UseAPIKey(ctx context.Context, hash string) (postgres.APIKey, error)
+}
+
+// APIKeys lets routes accept API keys in place of a JWT.
+type APIKeys struct {
+	keys    APIKeyUser
+	limiter *ratelimit.Limiter
+	policy  ratelimit.Policy
+}
+
+// NewAPIKeys returns API key authentication limiting keys without a limit of
+// their own to defaultLimit, a requests/period[:burst] policy.
+func NewAPIKeys(keys APIKeyUser, limiter *ratelimit.Limiter, defaultLimit string) (*APIKeys, error) {
+	policy, err := ratelimit.ParsePolicy(defaultLimit)
+	if err != nil {
+		return nil, fmt.Errorf("invalid api key rate limit %q: %w", defaultLimit, err)
+	}
+
+	return &APIKeys{keys: keys, limiter: limiter, policy: policy}, nil
+}
+
+// Authenticate returns the key token if it is valid and granted scope, and
+// records its use.
+func (a *APIKeys) Authenticate(ctx context.Context, token, scope string) (postgres.APIKey, error) {
+	key, err := a.keys.UseAPIKey(ctx, apikey.Hash(token))
+	if err != nil {
+		return postgres.APIKey{}, err
+	}
+
+	if !key.HasScope(scope) {
+		slog.InfoContext(ctx, "api key lacks scope", "api_key", key.ID, "scope", scope)
+		return postgres.APIKey{}, storage.ErrInsufficientScope
+	}
+
+	return key, nil
+}
+
+// Take counts a request of key against its rate limit. If the limiter fails
+// the request is let through, as with route limits.
+func (a *APIKeys) Take(ctx context.Context, key postgres.APIKey) error {
+	res, err := a.limiter.Take(ctx, limitKey(key), a.limit(key))
+	if err != nil {
+		slog.ErrorContext(ctx, "rate limiter failed", "error", err)
+		return nil
+	}
+	if !res.Allowed {
+		return storage.ErrTooManyRequests
+	}
+
+	return nil
+}
+
+// Allow accepts API keys granted scope on a route, passed in the
+// Authorization header like a JWT. Requests with a JWT are passed on
+// untouched; routes without Allow refuse keys since they are not JWTs.
+func (a *APIKeys) Allow(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !apikey.IsKey(token) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key, err := a.Authenticate(r.Context(), token, scope)
+			if err != nil {
+				problem.FromError(w, r, err)
+				return
+			}
+
+			if !a.limiter.Allow(w, r, limitKey(key), a.limit(key)) {
+				return
+			}
+
+			ctx := logging.With(r.Context(), "user", key.Login, "api_key", key.ID)
+
+			next.ServeHTTP(w, r.WithContext(auth.WithLogin(ctx, key.Login)))
+		})
+	}
+}
+
+// DefaultLimit is the rate limit of keys without their own. Keys may only
+// have a stricter one.
+func (a *APIKeys) DefaultLimit() ratelimit.Policy {
+	return a.policy
+}
+
+// limit returns the rate limit of key: its own if that is within the
+// default, otherwise the default.
+func (a *APIKeys) limit(key postgres.APIKey) ratelimit.Policy {
+	if key.RateLimit != "" {
+		if p, err := ratelimit.ParsePolicy(key.RateLimit); err == nil && p.Within(a.policy) {
+			return p
+		}
+	}
+
+	return a.policy
+}
+
+func limitKey(key postgres.APIKey) string {
+	return "apikey:" + strconv.FormatInt(key.ID, 10)
+}
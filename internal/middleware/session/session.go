@@ -0,0 +1,46 @@
+package session
+
+import (
+	"context"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/auth"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/http-server/problem"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"net/http"
+)
+
+type VersionGetter interface {
+	GetSessionVersion(ctx context.Context, login string) (int, error)
+}
+
+// Current rejects requests whose JWT was revoked by a password change or
+// reset. Requests without a valid token are passed on for the handler to
+// refuse. Like the WebSocket handler, it also looks at the token query
+// parameter.
+func Current(sessions VersionGetter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := r.Header.Get("Authorization")
+			if token == "" {
+				token = r.URL.Query().Get("token")
+			}
+
+			claims := auth.GetClaims(token)
+			if claims == nil || claims.Login == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			version, err := sessions.GetSessionVersion(r.Context(), claims.Login)
+			if err != nil {
+				problem.FromError(w, r, err)
+				return
+			}
+			if version != claims.SessionVersion {
+				problem.FromError(w, r, storage.ErrSessionRevoked)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notifier delivers password reset tokens to users. Users have no contact
+// details here, so a production notifier looks them up by login.
+type Notifier interface {
+	PasswordReset(ctx context.Context, login, token string, expiresAt time.Time) error
+}
+
+// New returns the notifier for spec: "log" or "file:<path>". Both hand the
+// token to whoever reads the output and are meant for development.
+func New(spec string) (Notifier, error) {
+	switch {
+	case spec == "log":
+		return LogNotifier{}, nil
+	case strings.HasPrefix(spec, "file:"):
+		return NewFileNotifier(strings.TrimPrefix(spec, "file:")), nil
+	}
+
+	return nil, fmt.Errorf("unknown password notifier %q", spec)
+}
+
+// LogNotifier writes reset tokens to the service log.
+type LogNotifier struct{}
+
+func (LogNotifier) PasswordReset(ctx context.Context, login, token string, expiresAt time.Time) error {
+	slog.InfoContext(ctx, "password reset requested", "user", login, "reset_token", token, "expires_at", expiresAt)
+
+	return nil
+}
+
+// FileNotifier appends reset tokens to a file, one JSON object per line.
+type FileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+func (n *FileNotifier) PasswordReset(_ context.Context, login, token string, expiresAt time.Time) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open notification file: %w", err)
+	}
+
+	err = json.NewEncoder(f).Encode(struct {
+		Type      string    `json:"type"`
+		Login     string    `json:"login"`
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{"password_reset", login, token, expiresAt})
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write notification: %w", err)
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,171 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/oidc/oidctest"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+const (
+	testClientID    = "gophermart"
+	testRedirectURL = "http://gophermart.test/api/user/oidc/callback"
+)
+
+// authorize starts a login with p and returns the code it redirects back with.
+func authorize(t *testing.T, p *Provider, state, nonce, verifier string) string {
+	t.Helper()
+
+	target, err := p.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorization failed: %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if got := location.Query().Get("state"); got != state {
+		t.Fatalf("state = %q, want %q", got, state)
+	}
+
+	return location.Query().Get("code")
+}
+
+func TestExchange(t *testing.T) {
+	idp := oidctest.NewProvider(testClientID)
+	defer idp.Close()
+
+	tests := []struct {
+		name    string
+		user    oidctest.User
+		want    Identity
+		wantErr error
+	}{
+		{
+			name: "valid token",
+			user: oidctest.User{Subject: "sub-1", Email: "alice@example.com", PreferredUsername: "alice"},
+			want: Identity{Subject: "sub-1", Email: "alice@example.com", PreferredUsername: "alice"},
+		},
+		{
+			name:    "nonce mismatch",
+			user:    oidctest.User{Subject: "sub-1", Nonce: "replayed"},
+			wantErr: ErrInvalidIDToken,
+		},
+		{
+			name:    "wrong audience",
+			user:    oidctest.User{Subject: "sub-1", Audience: "another-client"},
+			wantErr: ErrInvalidIDToken,
+		},
+		{
+			name:    "wrong issuer",
+			user:    oidctest.User{Subject: "sub-1", Issuer: "https://idp.example.com"},
+			wantErr: ErrInvalidIDToken,
+		},
+		{
+			name:    "unknown key",
+			user:    oidctest.User{Subject: "sub-1", KeyID: "rotated"},
+			wantErr: ErrInvalidIDToken,
+		},
+		{
+			name:    "no subject",
+			user:    oidctest.User{Email: "alice@example.com"},
+			wantErr: ErrInvalidIDToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(idp.Issuer(), testClientID, "secret", testRedirectURL)
+			idp.SetUser(tt.user)
+
+			code := authorize(t, p, "state", "nonce", "verifier")
+
+			got, err := p.Exchange(context.Background(), code, "verifier", "nonce")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("identity = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExchangeForwardsVerifier(t *testing.T) {
+	idp := oidctest.NewProvider(testClientID)
+	defer idp.Close()
+	idp.SetUser(oidctest.User{Subject: "sub-1"})
+
+	p := New(idp.Issuer(), testClientID, "", testRedirectURL)
+
+	verifier, err := RandomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := authorize(t, p, "state", "nonce", verifier)
+	if _, err := p.Exchange(context.Background(), code, verifier, "nonce"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := idp.Verifiers(); len(got) != 1 || got[0] != verifier {
+		t.Errorf("verifiers = %q, want [%q]", got, verifier)
+	}
+
+	// The code was redeemed.
+	if _, err := p.Exchange(context.Background(), code, verifier, "nonce"); !errors.Is(err, ErrCodeRejected) {
+		t.Errorf("reused code: err = %v, want %v", err, ErrCodeRejected)
+	}
+
+	// A verifier that does not match the challenge is refused by the provider.
+	code = authorize(t, p, "state", "nonce", verifier)
+	if _, err := p.Exchange(context.Background(), code, "another-verifier", "nonce"); !errors.Is(err, ErrCodeRejected) {
+		t.Errorf("wrong verifier: err = %v, want %v", err, ErrCodeRejected)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	idp := oidctest.NewProvider(testClientID)
+	defer idp.Close()
+
+	p := New(idp.Issuer()+"/", testClientID, "", testRedirectURL)
+
+	if _, err := p.AuthCodeURL(context.Background(), "state", "nonce", "verifier"); err == nil {
+		t.Error("expected an error for a provider reporting another issuer")
+	}
+}
+
+func TestIdentityLogin(t *testing.T) {
+	tests := []struct {
+		identity Identity
+		want     string
+	}{
+		{Identity{Subject: "sub", Email: "alice@example.com", PreferredUsername: "alice"}, "alice"},
+		{Identity{Subject: "sub", Email: "alice@example.com"}, "alice@example.com"},
+		{Identity{Subject: "sub"}, "sub"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.identity.Login(); got != tt.want {
+			t.Errorf("%+v.Login() = %q, want %q", tt.identity, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+// Package oidctest provides a local OpenID Connect provider for tests of the
+// login flow.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// KeyID is the ID of the signing key the provider publishes.
+const KeyID = "test-key"
+
+// User is who the provider logs in at the authorization endpoint. The other
+// fields, when set, spoil the ID token to test its verification.
+type User struct {
+	Subject           string
+	Email             string
+	PreferredUsername string
+
+	Issuer   string
+	Audience string
+	Nonce    string
+	KeyID    string
+}
+
+// Provider is an identity provider that logs in User without asking and
+// signs ID tokens with a key generated on start.
+type Provider struct {
+	*httptest.Server
+
+	ClientID string
+
+	mu        sync.Mutex
+	user      User
+	key       *rsa.PrivateKey
+	grants    map[string]grant
+	verifiers []string
+}
+
+type grant struct {
+	user      User
+	nonce     string
+	challenge string
+}
+
+// NewProvider starts a provider for the client. Close it when done.
+func NewProvider(clientID string) *Provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic("oidctest: failed to generate key: " + err.Error())
+	}
+
+	p := &Provider{
+		ClientID: clientID,
+		key:      key,
+		grants:   make(map[string]grant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+	p.Server = httptest.NewServer(mux)
+
+	return p
+}
+
+// Issuer returns the issuer identifier of the provider.
+func (p *Provider) Issuer() string {
+	return p.URL
+}
+
+// SetUser sets who the next authorization logs in.
+func (p *Provider) SetUser(u User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.user = u
+}
+
+// Verifiers returns the PKCE code verifiers sent to the token endpoint.
+func (p *Provider) Verifiers() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.verifiers...)
+}
+
+// Authorize grants a code for the query of an authorization request, as if
+// the user logged in, and returns it.
+func (p *Provider) Authorize(query url.Values) string {
+	code := randomString()
+
+	p.mu.Lock()
+	p.grants[code] = grant{user: p.user, nonce: query.Get("nonce"), challenge: query.Get("code_challenge")}
+	p.mu.Unlock()
+
+	return code
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 p.URL,
+		"authorization_endpoint": p.URL + "/authorize",
+		"token_endpoint":         p.URL + "/token",
+		"jwks_uri":               p.URL + "/jwks",
+	})
+}
+
+// authorize redirects back to the client with a code and the state.
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != p.ClientID || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	target, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	callback := target.Query()
+	callback.Set("code", p.Authorize(query))
+	callback.Set("state", query.Get("state"))
+	target.RawQuery = callback.Encode()
+
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// token redeems a code once, checking the PKCE verifier against the
+// challenge of the authorization request.
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	verifier := r.PostForm.Get("code_verifier")
+	sum := sha256.Sum256([]byte(verifier))
+
+	p.mu.Lock()
+	g, ok := p.grants[r.PostForm.Get("code")]
+	delete(p.grants, r.PostForm.Get("code"))
+	p.verifiers = append(p.verifiers, verifier)
+	p.mu.Unlock()
+
+	if !ok || r.PostForm.Get("client_id") != p.ClientID || base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken, err := p.sign(g)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (p *Provider) sign(g grant) (string, error) {
+	u := g.user
+
+	issuer, audience, nonce, kid := p.URL, p.ClientID, g.nonce, KeyID
+	if u.Issuer != "" {
+		issuer = u.Issuer
+	}
+	if u.Audience != "" {
+		audience = u.Audience
+	}
+	if u.Nonce != "" {
+		nonce = u.Nonce
+	}
+	if u.KeyID != "" {
+		kid = u.KeyID
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   issuer,
+		"aud":   audience,
+		"sub":   u.Subject,
+		"nonce": nonce,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+	}
+	if u.Email != "" {
+		claims["email"] = u.Email
+	}
+	if u.PreferredUsername != "" {
+		claims["preferred_username"] = u.PreferredUsername
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+
+	return token.SignedString(p.key)
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.PublicKey
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": KeyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("oidctest: failed to read random bytes: " + err.Error())
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
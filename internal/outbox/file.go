@@ -0,0 +1,49 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"os"
+	"sync"
+)
+
+// FilePublisher appends events to a file, one JSON object per line.
+type FilePublisher struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFilePublisher(path string) *FilePublisher {
+	return &FilePublisher{path: path}
+}
+
+func (p *FilePublisher) Name() string {
+	return "file:" + p.path
+}
+
+func (p *FilePublisher) Publish(_ context.Context, events []postgres.DomainEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, err := os.OpenFile(p.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open event file: %w", err)
+	}
+
+	enc := json.NewEncoder(f)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write event: %w", err)
+		}
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync event file: %w", err)
+	}
+
+	return f.Close()
+}
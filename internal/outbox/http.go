@@ -0,0 +1,60 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTPPublisher POSTs each batch of events as NDJSON to a URL. Any non-2xx
+// response makes the batch be retried.
+type HTTPPublisher struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPPublisher(url string) *HTTPPublisher {
+	return &HTTPPublisher{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *HTTPPublisher) Name() string {
+	return p.url
+}
+
+func (p *HTTPPublisher) Publish(ctx context.Context, events []postgres.DomainEvent) error {
+	var body bytes.Buffer
+
+	enc := json.NewEncoder(&body)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create events request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send events: %w", err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("event sink responded with status %d", res.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/health"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const batchSize = 100
+
+// Publisher delivers domain events to a downstream consumer. Publish is
+// called with events in feed order and must either accept the whole batch or
+// return an error, in which case the batch is retried. A batch may be
+// published twice, so consumers should skip event ids they have seen.
+type Publisher interface {
+	Name() string
+	Publish(ctx context.Context, events []postgres.DomainEvent) error
+}
+
+type EventRelay interface {
+	RelayEvents(ctx context.Context, cursor string, limit int, publish func([]postgres.DomainEvent) error) (int, error)
+}
+
+// NewPublisher builds a publisher from a sink address: "file:<path>" writes
+// NDJSON to a file, an http(s) URL receives NDJSON batches via POST.
+func NewPublisher(sink string) (Publisher, error) {
+	switch {
+	case strings.HasPrefix(sink, "file:"):
+		return NewFilePublisher(strings.TrimPrefix(strings.TrimPrefix(sink, "file:"), "//")), nil
+	case strings.HasPrefix(sink, "http://"), strings.HasPrefix(sink, "https://"):
+		return NewHTTPPublisher(sink), nil
+	}
+
+	return nil, fmt.Errorf("unsupported event sink %q", sink)
+}
+
+// Run relays new events to the publisher every interval until ctx is cancelled.
+func Run(ctx context.Context, relay EventRelay, publisher Publisher, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	worker := "relay:" + publisher.Name()
+	health.Register(worker, interval)
+	defer health.Exit(worker)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			health.Beat(worker)
+			for {
+				n, err := relay.RelayEvents(ctx, publisher.Name(), batchSize, func(events []postgres.DomainEvent) error {
+					return publisher.Publish(ctx, events)
+				})
+				if err != nil {
+					slog.Error("failed to relay events", "sink", publisher.Name(), "error", err)
+				}
+				if err != nil || n < batchSize {
+					break
+				}
+			}
+		}
+	}
+}
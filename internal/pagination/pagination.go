@@ -0,0 +1,122 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const MaxLimit = 1000
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ParseFilter reads limit, cursor, status, from, to and sort from the query
+// string. statuses lists the values accepted by the status filter; nil
+// disables it. Lists are sorted newest first unless sort=asc is given.
+func ParseFilter(r *http.Request, statuses []string) (postgres.ListFilter, error) {
+	q := r.URL.Query()
+	filter := postgres.ListFilter{Desc: true}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > MaxLimit {
+			return postgres.ListFilter{}, fmt.Errorf("limit must be between 1 and %d", MaxLimit)
+		}
+		filter.Limit = limit
+	}
+
+	switch q.Get("sort") {
+	case "", "desc":
+	case "asc":
+		filter.Desc = false
+	default:
+		return postgres.ListFilter{}, errors.New("sort must be asc or desc")
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		cursor, err := DecodeCursor(v)
+		if err != nil {
+			return postgres.ListFilter{}, err
+		}
+		filter.After = &cursor
+	}
+
+	if v := q.Get("status"); v != "" {
+		for _, status := range strings.Split(v, ",") {
+			if !contains(statuses, status) {
+				return postgres.ListFilter{}, fmt.Errorf("unknown status %q", status)
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+
+	for name, dst := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return postgres.ListFilter{}, fmt.Errorf("%s must be an RFC3339 date", name)
+			}
+			*dst = t
+		}
+	}
+
+	return filter, nil
+}
+
+// EncodeCursor turns a keyset position into an opaque token.
+func EncodeCursor(c postgres.Cursor) string {
+	raw := strconv.FormatInt(c.At.UnixNano(), 10) + ":" + strconv.FormatInt(c.ID, 10)
+
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeCursor(s string) (postgres.Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return postgres.Cursor{}, ErrInvalidCursor
+	}
+
+	at, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return postgres.Cursor{}, ErrInvalidCursor
+	}
+
+	nanos, err := strconv.ParseInt(at, 10, 64)
+	if err != nil {
+		return postgres.Cursor{}, ErrInvalidCursor
+	}
+
+	rowID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return postgres.Cursor{}, ErrInvalidCursor
+	}
+
+	return postgres.Cursor{At: time.Unix(0, nanos).UTC(), ID: rowID}, nil
+}
+
+// SetNextLink advertises the next page in a Link header, keeping the other
+// query parameters of the request.
+func SetNextLink(w http.ResponseWriter, r *http.Request, next postgres.Cursor) {
+	q := r.URL.Query()
+	q.Set("cursor", EncodeCursor(next))
+
+	u := *r.URL
+	u.RawQuery = q.Encode()
+
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, u.String()))
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,167 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"strings"
+	"time"
+)
+
+// APIKey is a long-lived credential of a user for integrations. Only the
+// first characters of the key are kept in clear.
+type APIKey struct {
+	ID         int64      `json:"id"`
+	Login      string     `json:"-"`
+	Name       string     `json:"name"`
+	Display    string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	RateLimit  string     `json:"rate_limit,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// HasScope reports whether the key was granted scope.
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+const apiKeyColumns = `id, user_login, name, display, array_to_string(scopes, ' '), rate_limit, created_at, last_used_at`
+
+func scanAPIKey(row interface{ Scan(...any) error }) (APIKey, error) {
+	var (
+		k         APIKey
+		scopes    string
+		rateLimit sql.NullString
+		lastUsed  sql.NullTime
+	)
+
+	err := row.Scan(&k.ID, &k.Login, &k.Name, &k.Display, &scopes, &rateLimit, &k.CreatedAt, &lastUsed)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	k.Scopes = strings.Fields(scopes)
+	k.RateLimit = rateLimit.String
+	if lastUsed.Valid {
+		k.LastUsedAt = &lastUsed.Time
+	}
+
+	return k, nil
+}
+
+// CreateAPIKey stores the hash of a new key of the user. An empty rateLimit
+// leaves the key on the default limit.
+func (s *Storage) CreateAPIKey(ctx context.Context, login, name, display, hash string, scopes []string, rateLimit string) (APIKey, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return APIKey{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	k, err := scanAPIKey(tx.QueryRowContext(ctx, `
+	INSERT INTO api_keys(user_login, name, display, key_hash, scopes, rate_limit)
+	VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+	RETURNING `+apiKeyColumns, login, name, display, hash, scopes, rateLimit))
+	if err != nil {
+		return APIKey{}, fmt.Errorf("failed to insert api key: %w", err)
+	}
+
+	err = appendAudit(ctx, tx, login, AuditAPIKeyCreated, "user:"+login, map[string]any{
+		"key_id": k.ID,
+		"name":   name,
+		"scopes": scopes,
+	})
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return APIKey{}, fmt.Errorf("failed to commit api key: %w", err)
+	}
+
+	return k, nil
+}
+
+// GetAPIKeys returns the keys of the user that were not revoked, newest
+// first.
+func (s *Storage) GetAPIKeys(ctx context.Context, login string) ([]APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT `+apiKeyColumns+` FROM api_keys
+	WHERE user_login = $1 AND revoked_at IS NULL
+	ORDER BY id DESC`, login)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query api keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make([]APIKey, 0)
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate api keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey stops a key of the user from being accepted.
+func (s *Storage) RevokeAPIKey(ctx context.Context, login string, id int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+	UPDATE api_keys SET revoked_at = now()
+	WHERE id = $1 AND user_login = $2 AND revoked_at IS NULL`, id, login)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.ErrAPIKeyNotFound
+	}
+
+	if err := appendAudit(ctx, tx, login, AuditAPIKeyRevoked, "user:"+login, map[string]any{"key_id": id}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit api key revocation: %w", err)
+	}
+
+	return nil
+}
+
+// UseAPIKey returns the key with the given hash and records that it was
+// used. Keys of disabled accounts are refused like revoked ones.
+func (s *Storage) UseAPIKey(ctx context.Context, hash string) (APIKey, error) {
+	k, err := scanAPIKey(s.db.QueryRowContext(ctx, `
+	UPDATE api_keys SET last_used_at = now()
+	WHERE key_hash = $1 AND revoked_at IS NULL
+	  AND user_login IN (SELECT login FROM users WHERE disabled_at IS NULL)
+	RETURNING `+apiKeyColumns, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, storage.ErrInvalidAPIKey
+	}
+	if err != nil {
+		return APIKey{}, fmt.Errorf("failed to use api key: %w", err)
+	}
+
+	return k, nil
+}
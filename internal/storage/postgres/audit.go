@@ -0,0 +1,365 @@
+package postgres
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/audit"
+	"slices"
+	"time"
+)
+
+// Audit actions.
+const (
+	AuditUserRegistered = "user.registered"
+	AuditLoginSucceeded = "login.succeeded"
+	AuditLoginFailed    = "login.failed"
+	AuditLoginLocked    = "login.locked"
+	AuditLoginUnlocked  = "login.unlocked"
+
+	AuditPasswordChanged        = "password.changed"
+	AuditPasswordResetRequested = "password.reset_requested"
+	AuditPasswordReset          = "password.reset"
+
+	AuditMFAEnabled          = "mfa.enabled"
+	AuditMFADisabled         = "mfa.disabled"
+	AuditMFARecoveryCodeUsed = "mfa.recovery_code_used"
+
+	AuditOIDCLinked = "oidc.linked"
+
+	AuditAPIKeyCreated = "api_key.created"
+	AuditAPIKeyRevoked = "api_key.revoked"
+
+	AuditUserViewed       = "user.viewed"
+	AuditUserRoleChanged  = "user.role_changed"
+	AuditUserDisabled     = "user.disabled"
+	AuditUserEnabled      = "user.enabled"
+	AuditBalanceCredited  = "balance.credited"
+	AuditBalanceWithdrawn = "balance.withdrawn"
+	AuditBalanceAdjusted  = "balance.adjusted"
+	AuditOrderRechecked   = "order.rechecked"
+)
+
+// Actors of entries not made by a known user.
+const (
+	// AuditActorSystem is the actor of entries the service records on its own.
+	AuditActorSystem = "system"
+	// AuditActorAnonymous is the actor of failed logins, whoever they claim to be.
+	AuditActorAnonymous = "anonymous"
+)
+
+// auditChainLock is the advisory lock that serialises chaining, so every
+// entry is chained to the one committed before it.
+const auditChainLock = 0x61756469
+
+// AuditEntry is a record of the audit log. Each entry carries the hash of
+// the previous one, so changing, removing or reordering entries breaks the
+// chain.
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target"`
+	Details   json.RawMessage `json:"details"`
+	IP        string          `json:"ip,omitempty"`
+	UserAgent string          `json:"user_agent,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	PrevHash  string          `json:"prev_hash"`
+	Hash      string          `json:"hash"`
+}
+
+// AuditQuery narrows the audit entries listed. Empty fields match anything.
+type AuditQuery struct {
+	Actor  string
+	Action string
+	Target string
+}
+
+// Verify reports whether the entry follows the entry with prevHash and its
+// hash matches its contents.
+func (e AuditEntry) Verify(prevHash string) bool {
+	hash, err := e.computeHash()
+
+	return err == nil && e.PrevHash == prevHash && e.Hash == hash
+}
+
+// computeHash hashes the entry with the hash of the previous one. Details are
+// re-encoded so the hash does not depend on how the database formats JSON.
+func (e AuditEntry) computeHash() (string, error) {
+	var details any
+	if err := json.Unmarshal(e.Details, &details); err != nil {
+		return "", fmt.Errorf("failed to decode audit details: %w", err)
+	}
+
+	payload, err := json.Marshal([]any{
+		e.PrevHash, e.Actor, e.Action, e.Target, details, e.IP, e.UserAgent,
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+
+	sum := sha256.Sum256(payload)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// appendAudit records who did what to which target, from the client the
+// context came from. It runs in the transaction of the change it describes,
+// so the entry is kept exactly when the change is. The entry waits in
+// audit_pending until ChainAudit links it into the log, so appends take no
+// lock and changes do not wait for each other.
+func appendAudit(ctx context.Context, db execer, actor, action, target string, details any) error {
+	payload, err := json.Marshal(details)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit details: %w", err)
+	}
+
+	source := audit.SourceFrom(ctx)
+
+	_, err = db.ExecContext(ctx, `
+	INSERT INTO audit_pending(actor, action, target, details, ip, user_agent, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		actor, action, target, payload, source.IP, source.UserAgent, time.Now().UTC().Truncate(time.Microsecond))
+	if err != nil {
+		return fmt.Errorf("failed to append audit entry: %w", err)
+	}
+
+	return nil
+}
+
+// AppendAudit records an action that changes nothing in the database, such
+// as staff looking up a user.
+func (s *Storage) AppendAudit(ctx context.Context, actor, action, target string, details any) error {
+	return appendAudit(ctx, s.db, actor, action, target, details)
+}
+
+// ChainAudit moves up to limit pending entries, oldest first, into the audit
+// log, chaining each to the one before. Only this short transaction holds
+// the chain lock. It returns the number of entries chained.
+func (s *Storage) ChainAudit(ctx context.Context, limit int) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, auditChainLock); err != nil {
+		return 0, fmt.Errorf("failed to lock audit log: %w", err)
+	}
+
+	var prev string
+
+	err = tx.QueryRowContext(ctx, `SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1`).Scan(&prev)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("failed to query last audit entry: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+	DELETE FROM audit_pending WHERE id IN (SELECT id FROM audit_pending ORDER BY id LIMIT $1)
+	RETURNING id, actor, action, target, details::text, ip, user_agent, created_at`, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to take pending audit entries: %w", err)
+	}
+
+	// ID holds the pending id here, which only orders the entries: the log
+	// numbers them anew.
+	var entries []AuditEntry
+	for rows.Next() {
+		var (
+			e       AuditEntry
+			details string
+		)
+		if err := rows.Scan(&e.ID, &e.Actor, &e.Action, &e.Target, &details, &e.IP, &e.UserAgent, &e.CreatedAt); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan pending audit entry: %w", err)
+		}
+		e.Details = json.RawMessage(details)
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to iterate pending audit entries: %w", err)
+	}
+
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	slices.SortFunc(entries, func(a, b AuditEntry) int { return cmp.Compare(a.ID, b.ID) })
+
+	for _, e := range entries {
+		e.PrevHash = prev
+		if e.Hash, err = e.computeHash(); err != nil {
+			return 0, err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO audit_log(actor, action, target, details, ip, user_agent, created_at, prev_hash, hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			e.Actor, e.Action, e.Target, []byte(e.Details), e.IP, e.UserAgent, e.CreatedAt, e.PrevHash, e.Hash)
+		if err != nil {
+			return 0, fmt.Errorf("failed to chain audit entry: %w", err)
+		}
+		prev = e.Hash
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit audit chain: %w", err)
+	}
+
+	return len(entries), nil
+}
+
+const auditColumns = `id, actor, action, target, details::text, ip, user_agent, created_at, prev_hash, hash`
+
+func scanAuditEntry(row interface{ Scan(...any) error }) (AuditEntry, error) {
+	var (
+		e       AuditEntry
+		details string
+	)
+
+	err := row.Scan(&e.ID, &e.Actor, &e.Action, &e.Target, &details, &e.IP, &e.UserAgent, &e.CreatedAt, &e.PrevHash, &e.Hash)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	e.Details = json.RawMessage(details)
+
+	return e, nil
+}
+
+// GetAudit returns the audit entries matching q, newest first unless the
+// filter says otherwise.
+func (s *Storage) GetAudit(ctx context.Context, q AuditQuery, filter ListFilter) ([]AuditEntry, error) {
+	clauses, args := filter.where("created_at", []any{q.Actor, q.Action, q.Target})
+
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT `+auditColumns+` FROM audit_log
+	WHERE ($1 = '' OR actor = $1) AND ($2 = '' OR action = $2) AND ($3 = '' OR target = $3)`+clauses, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make([]AuditEntry, 0)
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate audit log: %w", err)
+	}
+
+	return entries, nil
+}
+
+// ExportAudit calls fn for every audit entry with an id greater than after,
+// in the order they were chained.
+func (s *Storage) ExportAudit(ctx context.Context, after int64, fn func(AuditEntry) error) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+auditColumns+` FROM audit_log WHERE id > $1 ORDER BY id`, after)
+	if err != nil {
+		return fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to iterate audit log: %w", err)
+	}
+
+	return nil
+}
+
+// sealAuditLog chains the entries written before the log was hashed and
+// makes the table append-only. It runs once; replicas starting together
+// wait for the first one.
+func sealAuditLog(db *sql.DB) error {
+	ctx := context.Background()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, auditChainLock); err != nil {
+		return fmt.Errorf("failed to lock audit log: %w", err)
+	}
+
+	var sealed bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM pg_trigger WHERE tgname = 'audit_log_append_only' AND tgrelid = 'audit_log'::regclass)`).Scan(&sealed)
+	if err != nil {
+		return fmt.Errorf("failed to check audit log trigger: %w", err)
+	}
+	if sealed {
+		return nil
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT `+auditColumns+` FROM audit_log ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("failed to query audit log: %w", err)
+	}
+
+	var entries []AuditEntry
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to iterate audit log: %w", err)
+	}
+
+	var prev string
+	for _, e := range entries {
+		e.PrevHash = prev
+		if e.Hash, err = e.computeHash(); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `UPDATE audit_log SET prev_hash = $2, hash = $3 WHERE id = $1`, e.ID, e.PrevHash, e.Hash); err != nil {
+			return fmt.Errorf("failed to chain audit entry: %w", err)
+		}
+		prev = e.Hash
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+	BEGIN
+		RAISE EXCEPTION 'audit_log is append-only';
+	END;
+	$$ LANGUAGE plpgsql;
+	CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_log
+	FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create audit_log trigger: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit audit log seal: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentAuditAppendsChain appends entries from many transactions
+// while two chainers run, as on two replicas, and checks the whole log still
+// forms one valid chain.
+func TestConcurrentAuditAppendsChain(t *testing.T) {
+	s := testStorage(t)
+	ctx := context.Background()
+	target := "test:" + testLogin()
+
+	const appenders, perAppender = 8, 25
+
+	var appending sync.WaitGroup
+	errs := make(chan error, appenders+2)
+	for i := 0; i < appenders; i++ {
+		appending.Add(1)
+		go func() {
+			defer appending.Done()
+			for j := 0; j < perAppender; j++ {
+				if err := s.AppendAudit(ctx, AuditActorSystem, AuditUserViewed, target, map[string]any{"n": j}); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	var chaining sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		chaining.Add(1)
+		go func() {
+			defer chaining.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if _, err := s.ChainAudit(ctx, 10); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	appending.Wait()
+	close(done)
+	chaining.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	for {
+		n, err := s.ChainAudit(ctx, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			break
+		}
+	}
+
+	var (
+		prev    string
+		lastID  int64
+		chained int
+	)
+	err := s.ExportAudit(ctx, 0, func(e AuditEntry) error {
+		if !e.Verify(prev) {
+			t.Errorf("entry %d does not follow entry %d", e.ID, lastID)
+		}
+		if e.Target == target {
+			chained++
+		}
+		prev, lastID = e.Hash, e.ID
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chained != appenders*perAppender {
+		t.Errorf("chained %d entries, want %d", chained, appenders*perAppender)
+	}
+}
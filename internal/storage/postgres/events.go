@@ -0,0 +1,213 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EventUserRegistered  = "UserRegistered"
+	EventOrderUploaded   = "OrderUploaded"
+	EventAccrualCredited = "AccrualCredited"
+	EventPointsWithdrawn = "PointsWithdrawn"
+	EventBalanceAdjusted = "BalanceAdjusted"
+)
+
+type DomainEvent struct {
+	ID        int64           `json:"id" db:"id"`
+	Type      string          `json:"type" db:"type"`
+	Login     string          `json:"user" db:"user_login"`
+	Payload   json.RawMessage `json:"payload" db:"payload"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+	TxID      uint64          `json:"-" db:"txid"`
+}
+
+// appendEvent writes a domain event to the outbox. It must run in the
+// transaction of the change it describes.
+func appendEvent(ctx context.Context, db execer, eventType, login string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	_, err = db.ExecContext(ctx, `INSERT INTO domain_events(type, user_login, payload) VALUES ($1, $2, $3)`, eventType, login, payload)
+	if err != nil {
+		return fmt.Errorf("failed to append event: %w", err)
+	}
+
+	return nil
+}
+
+// EventCursor is a position in the domain event feed. The feed is ordered by
+// the transaction that wrote an event and then by its id: ids are taken when
+// a row is inserted, so a transaction committing late can hold lower ids than
+// events already read, while its transaction id is above every one read.
+type EventCursor struct {
+	TxID uint64
+	ID   int64
+}
+
+func (c EventCursor) String() string {
+	return strconv.FormatUint(c.TxID, 10) + "-" + strconv.FormatInt(c.ID, 10)
+}
+
+var errInvalidEventCursor = errors.New("invalid event cursor")
+
+// ParseEventCursor reads a cursor written by EventCursor.String. An empty
+// string or "0" is the start of the feed.
+func ParseEventCursor(s string) (EventCursor, error) {
+	if s == "" || s == "0" {
+		return EventCursor{}, nil
+	}
+
+	txid, id, ok := strings.Cut(s, "-")
+	if !ok {
+		return EventCursor{}, errInvalidEventCursor
+	}
+
+	var (
+		c   EventCursor
+		err error
+	)
+	if c.TxID, err = strconv.ParseUint(txid, 10, 64); err != nil {
+		return EventCursor{}, errInvalidEventCursor
+	}
+	if c.ID, err = strconv.ParseInt(id, 10, 64); err != nil || c.ID < 0 {
+		return EventCursor{}, errInvalidEventCursor
+	}
+
+	return c, nil
+}
+
+// Cursor returns the position right after e.
+func (e DomainEvent) Cursor() EventCursor {
+	return EventCursor{TxID: e.TxID, ID: e.ID}
+}
+
+// GetEvents returns up to limit events that follow after. Events of
+// transactions that are still running are held back, so every later event
+// sorts after them.
+func (s *Storage) GetEvents(ctx context.Context, after EventCursor, limit int) ([]DomainEvent, error) {
+	return queryEvents(ctx, s.db, after, limit)
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func queryEvents(ctx context.Context, db querier, after EventCursor, limit int) ([]DomainEvent, error) {
+	rows, err := db.QueryContext(ctx, `
+	SELECT id, type, user_login, payload, created_at, txid::text FROM domain_events
+	WHERE (txid, id) > ($1::text::xid8, $2) AND txid < pg_snapshot_xmin(pg_current_snapshot())
+	ORDER BY txid ASC, id ASC LIMIT $3`, strconv.FormatUint(after.TxID, 10), after.ID, limit)
+	if err != nil {
+		return []DomainEvent{}, fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []DomainEvent{}
+
+	for rows.Next() {
+		var (
+			e    DomainEvent
+			txid string
+		)
+
+		if err := rows.Scan(&e.ID, &e.Type, &e.Login, &e.Payload, &e.CreatedAt, &txid); err != nil {
+			return []DomainEvent{}, fmt.Errorf("failed to scan event: %w", err)
+		}
+		if e.TxID, err = strconv.ParseUint(txid, 10, 64); err != nil {
+			return []DomainEvent{}, fmt.Errorf("failed to scan event: %w", err)
+		}
+
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return []DomainEvent{}, fmt.Errorf("failed to get events: %w", err)
+	}
+
+	return events, nil
+}
+
+// eventLease is how long a relay may take to publish a batch before another
+// replica takes the cursor over.
+const eventLease = time.Minute
+
+// RelayEvents passes the events that follow the named cursor to publish and
+// advances the cursor once publish succeeds. The cursor is leased rather than
+// locked while publish runs, so no transaction stays open during delivery;
+// a relay outliving its lease may see its batch published again.
+func (s *Storage) RelayEvents(ctx context.Context, cursor string, limit int, publish func([]DomainEvent) error) (int, error) {
+	after, ok, err := s.leaseEventCursor(ctx, cursor)
+	if err != nil || !ok {
+		return 0, err
+	}
+
+	events, err := queryEvents(ctx, s.db, after, limit)
+	if err == nil && len(events) > 0 {
+		err = publish(events)
+	}
+	if err != nil || len(events) == 0 {
+		if releaseErr := s.releaseEventCursor(ctx, cursor); releaseErr != nil && err == nil {
+			err = releaseErr
+		}
+		return 0, err
+	}
+
+	next := events[len(events)-1].Cursor()
+
+	_, err = s.db.ExecContext(ctx, `
+	UPDATE event_cursors SET last_txid = $2::text::xid8, last_id = $3, leased_until = '-infinity'
+	WHERE name = $1 AND (last_txid, last_id) < ($2::text::xid8, $3)`, cursor, strconv.FormatUint(next.TxID, 10), next.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save event cursor: %w", err)
+	}
+
+	return len(events), nil
+}
+
+// leaseEventCursor takes the named cursor for eventLease. It reports false if
+// another relay holds it.
+func (s *Storage) leaseEventCursor(ctx context.Context, cursor string) (EventCursor, bool, error) {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO event_cursors(name) VALUES ($1) ON CONFLICT DO NOTHING`, cursor)
+	if err != nil {
+		return EventCursor{}, false, fmt.Errorf("failed to create event cursor: %w", err)
+	}
+
+	var (
+		c    EventCursor
+		txid string
+	)
+
+	err = s.db.QueryRowContext(ctx, `
+	UPDATE event_cursors SET leased_until = NOW() + $2 * INTERVAL '1 second'
+	WHERE name = $1 AND leased_until <= NOW()
+	RETURNING last_txid::text, last_id`, cursor, eventLease.Seconds()).Scan(&txid, &c.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return EventCursor{}, false, nil
+	}
+	if err != nil {
+		return EventCursor{}, false, fmt.Errorf("failed to lease event cursor: %w", err)
+	}
+
+	if c.TxID, err = strconv.ParseUint(txid, 10, 64); err != nil {
+		return EventCursor{}, false, fmt.Errorf("failed to lease event cursor: %w", err)
+	}
+
+	return c, true, nil
+}
+
+func (s *Storage) releaseEventCursor(ctx context.Context, cursor string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE event_cursors SET leased_until = '-infinity' WHERE name = $1`, cursor)
+	if err != nil {
+		return fmt.Errorf("failed to release event cursor: %w", err)
+	}
+
+	return nil
+}
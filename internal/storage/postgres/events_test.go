@@ -0,0 +1,110 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseEventCursor(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    EventCursor
+		wantErr bool
+	}{
+		{in: "", want: EventCursor{}},
+		{in: "0", want: EventCursor{}},
+		{in: "771-42", want: EventCursor{TxID: 771, ID: 42}},
+		{in: "18446744073709551615-1", want: EventCursor{TxID: 1<<64 - 1, ID: 1}},
+		{in: "42", wantErr: true},
+		{in: "771-", wantErr: true},
+		{in: "-42", wantErr: true},
+		{in: "771--42", wantErr: true},
+		{in: "a-b", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseEventCursor(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseEventCursor(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseEventCursor(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+		if tt.in != "" && tt.in != "0" && got.String() != tt.in {
+			t.Errorf("%v.String() = %q, want %q", got, got.String(), tt.in)
+		}
+	}
+}
+
+// TestRelayEventsCommittedOutOfOrder relays an event whose id is lower than
+// that of an event already relayed, because its transaction committed later.
+func TestRelayEventsCommittedOutOfOrder(t *testing.T) {
+	s := testStorage(t)
+	ctx := context.Background()
+	cursor := testLogin()
+
+	drain := func() []DomainEvent {
+		t.Helper()
+
+		var relayed []DomainEvent
+		for {
+			n, err := s.RelayEvents(ctx, cursor, 1000, func(events []DomainEvent) error {
+				relayed = append(relayed, events...)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n == 0 {
+				return relayed
+			}
+		}
+	}
+	drain()
+
+	// early takes its transaction id first but its event id last.
+	early, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer early.Rollback()
+	if _, err := early.ExecContext(ctx, `SELECT pg_current_xact_id()`); err != nil {
+		t.Fatal(err)
+	}
+
+	late, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer late.Rollback()
+
+	if err := appendEvent(ctx, late, EventBalanceAdjusted, cursor, map[string]any{"tx": "late"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendEvent(ctx, early, EventBalanceAdjusted, cursor, map[string]any{"tx": "early"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := early.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	first := drain()
+
+	if err := late.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	second := drain()
+
+	var ids []int64
+	for _, e := range append(first, second...) {
+		if e.Login == cursor {
+			ids = append(ids, e.ID)
+		}
+	}
+	if len(ids) != 2 || ids[0] < ids[1] {
+		t.Errorf("relayed event ids %v, want both events with the higher id first", ids)
+	}
+}
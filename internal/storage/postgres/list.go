@@ -0,0 +1,63 @@
+package postgres
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Cursor is a keyset position in a list sorted by time and id.
+type Cursor struct {
+	At time.Time
+	ID int64
+}
+
+// ListFilter narrows and orders the rows returned by list queries. Zero
+// values mean no restriction; a zero Limit returns every matching row.
+type ListFilter struct {
+	Limit    int
+	After    *Cursor
+	Statuses []string
+	From     time.Time
+	To       time.Time
+	Desc     bool
+}
+
+// where appends the filter conditions on the given time column to a query
+// that already has its first argument bound, and returns the ORDER BY and
+// LIMIT clauses with the arguments to pass along.
+func (f ListFilter) where(timeColumn string, args []any) (string, []any) {
+	var b strings.Builder
+
+	if len(f.Statuses) > 0 {
+		args = append(args, f.Statuses)
+		fmt.Fprintf(&b, " AND status = ANY($%d)", len(args))
+	}
+	if !f.From.IsZero() {
+		args = append(args, f.From)
+		fmt.Fprintf(&b, " AND %s >= $%d", timeColumn, len(args))
+	}
+	if !f.To.IsZero() {
+		args = append(args, f.To)
+		fmt.Fprintf(&b, " AND %s < $%d", timeColumn, len(args))
+	}
+
+	op, dir := ">", "ASC"
+	if f.Desc {
+		op, dir = "<", "DESC"
+	}
+
+	if f.After != nil {
+		args = append(args, f.After.At, f.After.ID)
+		fmt.Fprintf(&b, " AND (%s, id) %s ($%d, $%d)", timeColumn, op, len(args)-1, len(args))
+	}
+
+	fmt.Fprintf(&b, " ORDER BY %s %s, id %s", timeColumn, dir, dir)
+
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		fmt.Fprintf(&b, " LIMIT $%d", len(args))
+	}
+
+	return b.String(), args
+}
@@ -0,0 +1,224 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/validation"
+	"sync"
+	"time"
+)
+
+// attemptPolicy throttles failed logins of one key: the first free failures
+// cost nothing, the next ones lock the key for a doubling delay and from
+// lockAt failures on every failure locks it for lockout.
+type attemptPolicy struct {
+	prefix  string
+	free    int
+	lockAt  int
+	lockout time.Duration
+}
+
+// Failures are counted per login and per client IP, so neither guessing the
+// password of one user from many addresses nor trying many users from one
+// address goes unnoticed. An IP is allowed more as it may be shared.
+var attemptPolicies = []attemptPolicy{
+	{prefix: "login:", free: 3, lockAt: 10, lockout: 15 * time.Minute},
+	{prefix: "ip:", free: 10, lockAt: 50, lockout: 15 * time.Minute},
+}
+
+// attemptWindow is how long after the last failure the count starts over.
+const attemptWindow = time.Hour
+
+func (p attemptPolicy) delay(failures int) time.Duration {
+	switch {
+	case failures >= p.lockAt:
+		return p.lockout
+	case failures > p.free:
+		// time.Second<<34 overflows; 2^30 seconds outlast any lockout.
+		shift := failures - p.free - 1
+		if shift > 30 {
+			return p.lockout
+		}
+		return min(time.Second<<shift, p.lockout)
+	}
+
+	return 0
+}
+
+// dummyHash is compared against when the login does not exist, so unknown
+// users take as long to reject as wrong passwords.
+var dummyHash = sync.OnceValue(func() string {
+	hash, _ := validation.HashPassword("not a password")
+	return hash
+})
+
+func attemptKeys(login, ip string) []string {
+	keys := []string{attemptPolicies[0].prefix + login, ""}
+	if ip != "" {
+		keys[1] = attemptPolicies[1].prefix + ip
+	}
+
+	return keys
+}
+
+// LoginLockedUntil returns when the lock on the login or the client IP ends,
+// or the zero time if neither is locked.
+func (s *Storage) LoginLockedUntil(ctx context.Context, login, ip string) (time.Time, error) {
+	var until sql.NullTime
+
+	keys := attemptKeys(login, ip)
+	err := s.db.QueryRowContext(ctx, `
+	SELECT MAX(locked_until) FROM login_attempts
+	WHERE (key = $1 OR key = $2) AND locked_until > now()`, keys[0], keys[1]).Scan(&until)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to check login lock: %w", err)
+	}
+
+	return until.Time, nil
+}
+
+// RecordLoginFailure counts a failed login against the login and the client
+// IP and locks them as their policies say. The failure and any lockouts are
+// audited. It returns when the longest resulting lock ends, or the zero time
+// if none was set.
+func (s *Storage) RecordLoginFailure(ctx context.Context, login, ip string) (time.Time, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	type lock struct {
+		key      string
+		failures int
+		until    time.Time
+	}
+
+	var lockedUntil time.Time
+	var locks []lock
+
+	for i, key := range attemptKeys(login, ip) {
+		if key == "" {
+			continue
+		}
+		p := attemptPolicies[i]
+
+		var failures int
+		err := tx.QueryRowContext(ctx, `
+		INSERT INTO login_attempts AS a (key, failures, last_failure) VALUES ($1, 1, now())
+		ON CONFLICT (key) DO UPDATE SET
+		    failures = CASE WHEN a.last_failure < now() - make_interval(secs => $2) THEN 1 ELSE a.failures + 1 END,
+		    last_failure = now()
+		RETURNING failures`, key, attemptWindow.Seconds()).Scan(&failures)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to record login failure: %w", err)
+		}
+
+		delay := p.delay(failures)
+		if delay == 0 {
+			continue
+		}
+
+		var until time.Time
+		err = tx.QueryRowContext(ctx, `
+		UPDATE login_attempts SET locked_until = now() + make_interval(secs => $2)
+		WHERE key = $1 RETURNING locked_until`, key, delay.Seconds()).Scan(&until)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to lock login: %w", err)
+		}
+		if until.After(lockedUntil) {
+			lockedUntil = until
+		}
+
+		if failures >= p.lockAt {
+			locks = append(locks, lock{key: key, failures: failures, until: until})
+		}
+	}
+
+	// Audit entries come last: appending one holds the audit log until commit.
+	if err := appendAudit(ctx, tx, AuditActorAnonymous, AuditLoginFailed, "user:"+login, map[string]any{}); err != nil {
+		return time.Time{}, err
+	}
+	for _, l := range locks {
+		err = appendAudit(ctx, tx, AuditActorSystem, AuditLoginLocked, l.key, map[string]any{
+			"failures":     l.failures,
+			"locked_until": l.until,
+		})
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, fmt.Errorf("failed to commit login failure: %w", err)
+	}
+
+	return lockedUntil, nil
+}
+
+// RecordLoginSuccess audits a completed login and forgets the failed logins
+// of the user. Failures of the client IP are kept, so a valid account cannot
+// be used to reset the count of an address guessing others.
+func (s *Storage) RecordLoginSuccess(ctx context.Context, login string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM login_attempts WHERE key = $1`, attemptPolicies[0].prefix+login)
+	if err != nil {
+		return fmt.Errorf("failed to reset login failures: %w", err)
+	}
+
+	if err := appendAudit(ctx, tx, login, AuditLoginSucceeded, "user:"+login, map[string]any{}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit login: %w", err)
+	}
+
+	return nil
+}
+
+// UnlockLogin lifts the lock on a login and forgets its failures on behalf
+// of actor.
+func (s *Storage) UnlockLogin(ctx context.Context, login, actor string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	key := attemptPolicies[0].prefix + login
+
+	var (
+		failures int
+		until    sql.NullTime
+	)
+	err = tx.QueryRowContext(ctx, `DELETE FROM login_attempts WHERE key = $1 RETURNING failures, locked_until`, key).Scan(&failures, &until)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.ErrNoLoginFailures
+	}
+	if err != nil {
+		return fmt.Errorf("failed to unlock login: %w", err)
+	}
+
+	err = appendAudit(ctx, tx, actor, AuditLoginUnlocked, key, map[string]any{
+		"failures":     failures,
+		"locked_until": until,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit unlock: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package postgres
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttemptPolicyDelay(t *testing.T) {
+	login, ip := attemptPolicies[0], attemptPolicies[1]
+	capped := attemptPolicy{free: 0, lockAt: 20, lockout: time.Minute}
+
+	tests := []struct {
+		name     string
+		policy   attemptPolicy
+		failures int
+		want     time.Duration
+	}{
+		{"login: no failures", login, 0, 0},
+		{"login: last free failure", login, 3, 0},
+		{"login: first delayed failure", login, 4, time.Second},
+		{"login: delay doubles", login, 5, 2 * time.Second},
+		{"login: last delayed failure", login, 9, 32 * time.Second},
+		{"login: locked out", login, 10, 15 * time.Minute},
+		{"login: stays locked out", login, 25, 15 * time.Minute},
+		{"ip: last free failure", ip, 10, 0},
+		{"ip: first delayed failure", ip, 11, time.Second},
+		{"ip: delay is capped by the lockout", ip, 49, 15 * time.Minute},
+		{"ip: locked out", ip, 50, 15 * time.Minute},
+		{"capped: below the lockout", capped, 6, 32 * time.Second},
+		{"capped: doubling past the lockout", capped, 7, time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.delay(tt.failures); got != tt.want {
+				t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+			}
+		})
+	}
+}
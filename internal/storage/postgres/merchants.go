@@ -0,0 +1,177 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"time"
+)
+
+// Merchant is a partner with its own accrual system. Credentials and
+// WebhookSecret are stored as given, since requests to the merchant need
+// the original values; the API never returns them.
+type Merchant struct {
+	ID            int64     `json:"id" db:"id"`
+	Name          string    `json:"name" db:"name"`
+	AccrualURL    string    `json:"accrual_url" db:"accrual_url"`
+	RateLimit     float64   `json:"rate_limit" db:"rate_limit"`
+	Burst         int       `json:"burst" db:"burst"`
+	Credentials   string    `json:"-" db:"credentials"`
+	WebhookSecret string    `json:"-" db:"webhook_secret"`
+	WebhookURL    string    `json:"webhook_url" db:"webhook_url"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+const merchantColumns = `id, name, accrual_url, rate_limit, burst, credentials, webhook_secret, webhook_url, created_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMerchant(row rowScanner) (Merchant, error) {
+	var m Merchant
+
+	err := row.Scan(&m.ID, &m.Name, &m.AccrualURL, &m.RateLimit, &m.Burst, &m.Credentials, &m.WebhookSecret, &m.WebhookURL, &m.CreatedAt)
+
+	return m, err
+}
+
+func (s *Storage) SaveMerchant(ctx context.Context, m Merchant) (int64, error) {
+	var exists bool
+
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM merchants WHERE name = $1)", m.Name).Scan(&exists)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check merchant existence: %w", err)
+	}
+	if exists {
+		return 0, storage.ErrMerchantAlreadyExists
+	}
+
+	var id int64
+
+	err = s.db.QueryRowContext(ctx, `INSERT INTO merchants(name, accrual_url, rate_limit, burst, credentials, webhook_secret, webhook_url) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		m.Name, m.AccrualURL, m.RateLimit, m.Burst, m.Credentials, m.WebhookSecret, m.WebhookURL).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert merchant: %w", err)
+	}
+
+	return id, nil
+}
+
+func (s *Storage) GetMerchant(ctx context.Context, id int64) (Merchant, error) {
+	m, err := scanMerchant(s.db.QueryRowContext(ctx, `SELECT `+merchantColumns+` FROM merchants WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Merchant{}, storage.ErrMerchantNotFound
+	}
+	if err != nil {
+		return Merchant{}, fmt.Errorf("failed to query merchant: %w", err)
+	}
+
+	return m, nil
+}
+
+func (s *Storage) GetMerchantByName(ctx context.Context, name string) (Merchant, error) {
+	m, err := scanMerchant(s.db.QueryRowContext(ctx, `SELECT `+merchantColumns+` FROM merchants WHERE name = $1`, name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Merchant{}, storage.ErrMerchantNotFound
+	}
+	if err != nil {
+		return Merchant{}, fmt.Errorf("failed to query merchant: %w", err)
+	}
+
+	return m, nil
+}
+
+func (s *Storage) GetMerchants(ctx context.Context) ([]Merchant, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+merchantColumns+` FROM merchants ORDER BY id ASC`)
+	if err != nil {
+		return []Merchant{}, fmt.Errorf("failed to query merchants: %w", err)
+	}
+	defer rows.Close()
+
+	merchants := []Merchant{}
+
+	for rows.Next() {
+		m, err := scanMerchant(rows)
+		if err != nil {
+			return []Merchant{}, fmt.Errorf("failed to scan merchant: %w", err)
+		}
+
+		merchants = append(merchants, m)
+	}
+	if err := rows.Err(); err != nil {
+		return []Merchant{}, fmt.Errorf("failed to get merchants: %w", err)
+	}
+
+	return merchants, nil
+}
+
+// MerchantUpdate changes a merchant. A nil Credentials or WebhookSecret
+// keeps the stored value.
+type MerchantUpdate struct {
+	ID            int64
+	Name          string
+	AccrualURL    string
+	RateLimit     float64
+	Burst         int
+	Credentials   *string
+	WebhookSecret *string
+	WebhookURL    string
+}
+
+// UpdateMerchant applies u and returns the updated merchant. A webhook_url
+// needs a webhook secret, sent or already stored, or
+// storage.ErrWebhookSecretRequired is returned.
+func (s *Storage) UpdateMerchant(ctx context.Context, u MerchantUpdate) (Merchant, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Merchant{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var secret string
+
+	err = tx.QueryRowContext(ctx, `SELECT webhook_secret FROM merchants WHERE id = $1 FOR UPDATE`, u.ID).Scan(&secret)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Merchant{}, storage.ErrMerchantNotFound
+	}
+	if err != nil {
+		return Merchant{}, fmt.Errorf("failed to query merchant: %w", err)
+	}
+
+	if u.WebhookSecret != nil {
+		secret = *u.WebhookSecret
+	}
+	if u.WebhookURL != "" && secret == "" {
+		return Merchant{}, storage.ErrWebhookSecretRequired
+	}
+
+	m, err := scanMerchant(tx.QueryRowContext(ctx, `UPDATE merchants SET name = $1, accrual_url = $2, rate_limit = $3, burst = $4,
+		credentials = COALESCE($5, credentials), webhook_secret = COALESCE($6, webhook_secret), webhook_url = $7
+		WHERE id = $8 RETURNING `+merchantColumns,
+		u.Name, u.AccrualURL, u.RateLimit, u.Burst, u.Credentials, u.WebhookSecret, u.WebhookURL, u.ID))
+	if err != nil {
+		return Merchant{}, fmt.Errorf("failed to update merchant: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Merchant{}, fmt.Errorf("failed to commit merchant update: %w", err)
+	}
+
+	return m, nil
+}
+
+func (s *Storage) DeleteMerchant(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM merchants WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete merchant: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.ErrMerchantNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"testing"
+)
+
+func TestUpdateMerchantKeepsOmittedSecrets(t *testing.T) {
+	s := testStorage(t)
+	ctx := context.Background()
+
+	m := Merchant{
+		Name:          testLogin(),
+		AccrualURL:    "http://accrual.example",
+		Burst:         1,
+		Credentials:   "Basic c2VjcmV0",
+		WebhookSecret: "hook-secret",
+		WebhookURL:    "http://merchant.example/hook",
+	}
+	id, err := s.SaveMerchant(ctx, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.DeleteMerchant(ctx, id) })
+
+	got, err := s.UpdateMerchant(ctx, MerchantUpdate{ID: id, Name: m.Name, AccrualURL: "http://other.example", Burst: 2, WebhookURL: m.WebhookURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Credentials != m.Credentials || got.WebhookSecret != m.WebhookSecret || got.AccrualURL != "http://other.example" {
+		t.Errorf("UpdateMerchant without secrets = %+v, want the stored secrets kept", got)
+	}
+
+	empty := ""
+	_, err = s.UpdateMerchant(ctx, MerchantUpdate{ID: id, Name: m.Name, AccrualURL: m.AccrualURL, Burst: 1, WebhookSecret: &empty, WebhookURL: m.WebhookURL})
+	if !errors.Is(err, storage.ErrWebhookSecretRequired) {
+		t.Errorf("clearing the secret of a webhook: err = %v, want %v", err, storage.ErrWebhookSecretRequired)
+	}
+
+	got, err = s.UpdateMerchant(ctx, MerchantUpdate{ID: id, Name: m.Name, AccrualURL: m.AccrualURL, Burst: 1, Credentials: &empty, WebhookSecret: &empty})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Credentials != "" || got.WebhookSecret != "" {
+		t.Errorf("UpdateMerchant with empty secrets = %+v, want them cleared", got)
+	}
+
+	if _, err := s.UpdateMerchant(ctx, MerchantUpdate{ID: -1, Name: m.Name, AccrualURL: m.AccrualURL}); !errors.Is(err, storage.ErrMerchantNotFound) {
+		t.Errorf("unknown merchant: err = %v, want %v", err, storage.ErrMerchantNotFound)
+	}
+}
@@ -0,0 +1,182 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+)
+
+// MFA is the TOTP enrollment of a user. It takes effect once Enabled.
+type MFA struct {
+	Secret  string
+	Enabled bool
+}
+
+// StartMFAEnrollment stores a new TOTP secret and recovery code hashes for
+// the user, replacing an enrollment that was never confirmed.
+func (s *Storage) StartMFAEnrollment(ctx context.Context, login, secret string, recoveryHashes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+	INSERT INTO user_mfa(user_login, secret) VALUES ($1, $2)
+	ON CONFLICT (user_login) DO UPDATE SET secret = EXCLUDED.secret, last_step = 0, created_at = now()
+	WHERE NOT user_mfa.enabled`, login, secret)
+	if err != nil {
+		return fmt.Errorf("failed to store mfa secret: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.ErrMFAAlreadyEnabled
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_login = $1`, login)
+	if err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	for _, hash := range recoveryHashes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO mfa_recovery_codes(user_login, code_hash) VALUES ($1, $2)`, login, hash)
+		if err != nil {
+			return fmt.Errorf("failed to insert recovery code: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit mfa enrollment: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) GetMFA(ctx context.Context, login string) (MFA, error) {
+	var m MFA
+
+	err := s.db.QueryRowContext(ctx, `SELECT secret, enabled FROM user_mfa WHERE user_login = $1`, login).Scan(&m.Secret, &m.Enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return MFA{}, storage.ErrMFANotEnrolled
+	}
+	if err != nil {
+		return MFA{}, fmt.Errorf("failed to query mfa: %w", err)
+	}
+
+	return m, nil
+}
+
+// EnableMFA confirms the enrollment of the user with a code of the given
+// time step. It reports false if the step was already used.
+func (s *Storage) EnableMFA(ctx context.Context, login string, step int64) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+	UPDATE user_mfa SET enabled = TRUE, enabled_at = now(), last_step = $2
+	WHERE user_login = $1 AND NOT enabled AND last_step < $2`, login, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to enable mfa: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	if err := appendAudit(ctx, tx, login, AuditMFAEnabled, "user:"+login, map[string]any{}); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit mfa enabling: %w", err)
+	}
+
+	return true, nil
+}
+
+// UseMFAStep records that a code of the time step was accepted. It reports
+// false if that or a later step was used before, so codes cannot be replayed.
+func (s *Storage) UseMFAStep(ctx context.Context, login string, step int64) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE user_mfa SET last_step = $2 WHERE user_login = $1 AND last_step < $2`, login, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to use mfa step: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to use mfa step: %w", err)
+	}
+
+	return n == 1, nil
+}
+
+// UseRecoveryCode spends an unused recovery code of the user.
+func (s *Storage) UseRecoveryCode(ctx context.Context, login, hash string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+	UPDATE mfa_recovery_codes SET used_at = now()
+	WHERE user_login = $1 AND code_hash = $2 AND used_at IS NULL`, login, hash)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	var remaining int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_login = $1 AND used_at IS NULL`, login).Scan(&remaining)
+	if err != nil {
+		return false, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+
+	err = appendAudit(ctx, tx, login, AuditMFARecoveryCodeUsed, "user:"+login, map[string]any{"remaining": remaining})
+	if err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit recovery code use: %w", err)
+	}
+
+	return true, nil
+}
+
+// DisableMFA removes the enrollment and recovery codes of the user.
+func (s *Storage) DisableMFA(ctx context.Context, login string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM user_mfa WHERE user_login = $1`, login)
+	if err != nil {
+		return fmt.Errorf("failed to delete mfa: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.ErrMFANotEnrolled
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_login = $1`, login)
+	if err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	if err := appendAudit(ctx, tx, login, AuditMFADisabled, "user:"+login, map[string]any{}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit mfa removal: %w", err)
+	}
+
+	return nil
+}
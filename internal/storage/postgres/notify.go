@@ -0,0 +1,81 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/config"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/events"
+	"log/slog"
+	"time"
+)
+
+const eventsChannel = "gophermart_events"
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// notify publishes the event through NOTIFY. Inside a transaction it is only
+// delivered to listeners once the transaction commits.
+func notify(ctx context.Context, db execer, eventType, login string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	payload, err := json.Marshal(events.Event{Type: eventType, Login: login, Data: raw})
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	if _, err := db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, eventsChannel, string(payload)); err != nil {
+		return fmt.Errorf("failed to notify: %w", err)
+	}
+
+	return nil
+}
+
+// ListenEvents receives the events published by every gophermart replica and
+// passes them to dispatch until ctx is cancelled, reconnecting on failures.
+func (s *Storage) ListenEvents(ctx context.Context, dispatch func(events.Event)) {
+	for ctx.Err() == nil {
+		if err := listen(ctx, dispatch); err != nil && ctx.Err() == nil {
+			slog.Error("events listener failed", "error", err)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+func listen(ctx context.Context, dispatch func(events.Event)) error {
+	conn, err := pgx.Connect(ctx, config.DataBaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+eventsChannel); err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to wait for notification: %w", err)
+		}
+
+		var e events.Event
+		if err := json.Unmarshal([]byte(n.Payload), &e); err != nil {
+			slog.Error("invalid event payload", "error", err)
+			continue
+		}
+
+		dispatch(e)
+	}
+}
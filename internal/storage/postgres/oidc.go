@@ -0,0 +1,117 @@
+package postgres
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"time"
+)
+
+// oidcLogins lists the logins tried for a new user of the identity: the one
+// suggested by the provider, then that login with a suffix derived from the
+// identity, so the same identity always gets the same fallback.
+func oidcLogins(issuer, subject, login string) []string {
+	sum := sha256.Sum256([]byte(issuer + " " + subject))
+
+	return []string{login, login + "-" + hex.EncodeToString(sum[:4])}
+}
+
+// LinkOIDCUser returns the local user of the identity subject at issuer. On
+// the first login of the identity it creates a user named login, or login
+// with a suffix when the name is taken. With linkExisting a user already
+// named login is linked instead; only do that for issuers that own the logins.
+func (s *Storage) LinkOIDCUser(ctx context.Context, issuer, subject, login string, linkExisting bool) (string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var linked string
+
+	err = tx.QueryRowContext(ctx, `SELECT user_login FROM user_identities WHERE issuer = $1 AND subject = $2`, issuer, subject).Scan(&linked)
+	if err == nil {
+		return linked, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("failed to query identity: %w", err)
+	}
+
+	created := true
+	if linkExisting {
+		var exists bool
+
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE login = $1)`, login).Scan(&exists)
+		if err != nil {
+			return "", fmt.Errorf("failed to check user existence: %w", err)
+		}
+		if exists {
+			linked, created = login, false
+		}
+	}
+
+	if created {
+		for _, candidate := range oidcLogins(issuer, subject, login) {
+			var createdAt time.Time
+
+			// Users of the provider have no local password: the empty hash
+			// never verifies, a password can be set with a reset.
+			err = tx.QueryRowContext(ctx, `
+			INSERT INTO users(login, password) VALUES ($1, '')
+			ON CONFLICT (login) DO NOTHING RETURNING created_at`, candidate).Scan(&createdAt)
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			if err != nil {
+				return "", fmt.Errorf("failed to insert user: %w", err)
+			}
+
+			err = appendEvent(ctx, tx, EventUserRegistered, candidate, map[string]any{"login": candidate, "registered_at": createdAt})
+			if err != nil {
+				return "", err
+			}
+
+			linked = candidate
+			break
+		}
+		if linked == "" {
+			return "", fmt.Errorf("%w", storage.ErrLoginAlreadyExists)
+		}
+	}
+
+	// A user is linked to one identity per issuer.
+	res, err := tx.ExecContext(ctx, `
+	INSERT INTO user_identities(issuer, subject, user_login) VALUES ($1, $2, $3)
+	ON CONFLICT DO NOTHING`, issuer, subject, linked)
+	if err != nil {
+		return "", fmt.Errorf("failed to insert identity: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", fmt.Errorf("%w", storage.ErrLoginAlreadyExists)
+	}
+
+	if created {
+		if err := appendAudit(ctx, tx, linked, AuditUserRegistered, "user:"+linked, map[string]any{"issuer": issuer}); err != nil {
+			return "", err
+		}
+	}
+
+	err = appendAudit(ctx, tx, linked, AuditOIDCLinked, "user:"+linked, map[string]any{
+		"issuer":  issuer,
+		"subject": subject,
+		"created": created,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit identity: %w", err)
+	}
+
+	return linked, nil
+}
@@ -0,0 +1,73 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"regexp"
+	"testing"
+)
+
+func TestOIDCLogins(t *testing.T) {
+	logins := oidcLogins("https://idp.example.com", "sub-1", "alice")
+
+	if len(logins) != 2 || logins[0] != "alice" {
+		t.Fatalf("logins = %q, want alice first", logins)
+	}
+	if !regexp.MustCompile(`^alice-[0-9a-f]{8}$`).MatchString(logins[1]) {
+		t.Errorf("fallback = %q, want alice-<8 hex digits>", logins[1])
+	}
+
+	if again := oidcLogins("https://idp.example.com", "sub-1", "alice"); again[1] != logins[1] {
+		t.Errorf("fallback changed between calls: %q and %q", logins[1], again[1])
+	}
+	if other := oidcLogins("https://idp.example.com", "sub-2", "alice"); other[1] == logins[1] {
+		t.Errorf("identities share the fallback %q", other[1])
+	}
+}
+
+func TestLinkOIDCUser(t *testing.T) {
+	s := testStorage(t)
+	ctx := context.Background()
+
+	login := testLogin()
+	issuer := "https://idp.test/" + login
+
+	link := func(issuer, subject string, linkExisting bool) string {
+		t.Helper()
+
+		linked, err := s.LinkOIDCUser(ctx, issuer, subject, login, linkExisting)
+		if err != nil {
+			t.Fatalf("LinkOIDCUser(%s, %s): %v", issuer, subject, err)
+		}
+
+		return linked
+	}
+
+	// The first login creates the user.
+	if got := link(issuer, "sub-1", false); got != login {
+		t.Fatalf("first login = %q, want %q", got, login)
+	}
+	if _, err := s.GetUserRole(ctx, login); err != nil {
+		t.Fatalf("user was not created: %v", err)
+	}
+
+	// Later logins return the same user.
+	if got := link(issuer, "sub-1", false); got != login {
+		t.Errorf("second login = %q, want %q", got, login)
+	}
+
+	// Another identity asking for a taken login gets a suffix.
+	if got, want := link(issuer, "sub-2", false), oidcLogins(issuer, "sub-2", login)[1]; got != want {
+		t.Errorf("colliding login = %q, want %q", got, want)
+	}
+
+	// With linkExisting the user is linked instead, once per issuer.
+	if got := link(issuer+"/other", "sub-3", true); got != login {
+		t.Errorf("linked login = %q, want %q", got, login)
+	}
+	_, err := s.LinkOIDCUser(ctx, issuer, "sub-3", login, true)
+	if !errors.Is(err, storage.ErrLoginAlreadyExists) {
+		t.Errorf("second identity of an issuer: err = %v, want %v", err, storage.ErrLoginAlreadyExists)
+	}
+}
@@ -0,0 +1,178 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"time"
+)
+
+const (
+	StatusSourceUpload  = "upload"
+	StatusSourcePoll    = "poll"
+	StatusSourceWebhook = "webhook"
+	StatusSourceAdmin   = "admin"
+)
+
+// AccrualStatuses are the order statuses an accrual system may report.
+var AccrualStatuses = []string{"REGISTERED", "PROCESSING", "INVALID", "PROCESSED"}
+
+type OrderStatusEvent struct {
+	Status    string    `json:"status" db:"status"`
+	Accrual   float64   `json:"accrual,omitempty" db:"accrual"`
+	Source    string    `json:"source" db:"source"`
+	CreatedAt time.Time `json:"changed_at" db:"created_at"`
+}
+
+// AccrualLatency summarises how long a merchant's accrual system takes to
+// bring uploaded orders to a final status.
+type AccrualLatency struct {
+	MerchantID int64   `json:"merchant_id"`
+	Merchant   string  `json:"merchant"`
+	Orders     int64   `json:"orders"`
+	AvgSeconds float64 `json:"avg_seconds"`
+	P50Seconds float64 `json:"p50_seconds"`
+	P95Seconds float64 `json:"p95_seconds"`
+	MaxSeconds float64 `json:"max_seconds"`
+}
+
+type OrderDetails struct {
+	Order
+	Login       string             `json:"-"`
+	History     []OrderStatusEvent `json:"history"`
+	Withdrawals []Withdrawals      `json:"withdrawals"`
+}
+
+func appendStatusEvent(ctx context.Context, db execer, orderID, status string, accrual float64, source string) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO order_status_events(orderId, status, accrual, source) VALUES ($1, $2, $3, $4)`,
+		orderID, status, sql.NullFloat64{Float64: accrual, Valid: accrual != 0}, source)
+	if err != nil {
+		return fmt.Errorf("failed to append order status event: %w", err)
+	}
+
+	return nil
+}
+
+// GetOrder returns the order with its status history and the withdrawals
+// made against it. The caller checks Login to decide whether it may be shown.
+func (s *Storage) GetOrder(ctx context.Context, orderID string) (OrderDetails, error) {
+	var order OrderDetails
+	var accrual sql.NullFloat64
+
+	err := s.db.QueryRowContext(ctx, `SELECT id, orderId, status, accrual, uploaded_at, user_login FROM orders WHERE orderId = $1`, orderID).
+		Scan(&order.ID, &order.Number, &order.Status, &accrual, &order.UploadedAt, &order.Login)
+	if errors.Is(err, sql.ErrNoRows) {
+		return OrderDetails{}, storage.ErrOrderNotFound
+	}
+	if err != nil {
+		return OrderDetails{}, fmt.Errorf("failed to query order: %w", err)
+	}
+	order.Accrual = accrual.Float64
+
+	order.History, err = s.getOrderHistory(ctx, orderID)
+	if err != nil {
+		return OrderDetails{}, err
+	}
+
+	order.Withdrawals, err = s.getOrderWithdrawals(ctx, orderID)
+	if err != nil {
+		return OrderDetails{}, err
+	}
+
+	return order, nil
+}
+
+func (s *Storage) getOrderHistory(ctx context.Context, orderID string) ([]OrderStatusEvent, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT status, accrual, source, created_at FROM order_status_events WHERE orderId = $1 ORDER BY created_at ASC, id ASC`, orderID)
+	if err != nil {
+		return []OrderStatusEvent{}, fmt.Errorf("failed to query order history: %w", err)
+	}
+	defer rows.Close()
+
+	history := []OrderStatusEvent{}
+
+	for rows.Next() {
+		var e OrderStatusEvent
+		var accrual sql.NullFloat64
+
+		if err := rows.Scan(&e.Status, &accrual, &e.Source, &e.CreatedAt); err != nil {
+			return []OrderStatusEvent{}, fmt.Errorf("failed to scan order history: %w", err)
+		}
+		e.Accrual = accrual.Float64
+
+		history = append(history, e)
+	}
+	if err := rows.Err(); err != nil {
+		return []OrderStatusEvent{}, fmt.Errorf("failed to get order history: %w", err)
+	}
+
+	return history, nil
+}
+
+func (s *Storage) getOrderWithdrawals(ctx context.Context, orderID string) ([]Withdrawals, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, orderId, amount, processed_at FROM withdrawals WHERE orderId = $1 ORDER BY processed_at ASC`, orderID)
+	if err != nil {
+		return []Withdrawals{}, fmt.Errorf("failed to query order withdrawals: %w", err)
+	}
+	defer rows.Close()
+
+	withdrawals := []Withdrawals{}
+
+	for rows.Next() {
+		var w Withdrawals
+
+		if err := rows.Scan(&w.ID, &w.OrderID, &w.Sum, &w.ProcessedAt); err != nil {
+			return []Withdrawals{}, fmt.Errorf("failed to scan order withdrawal: %w", err)
+		}
+
+		withdrawals = append(withdrawals, w)
+	}
+	if err := rows.Err(); err != nil {
+		return []Withdrawals{}, fmt.Errorf("failed to get order withdrawals: %w", err)
+	}
+
+	return withdrawals, nil
+}
+
+// GetAccrualLatency measures, per merchant, the time between an order upload
+// and the first final status reported for it. Orders of the default accrual
+// system are reported with merchant id 0.
+func (s *Storage) GetAccrualLatency(ctx context.Context, since time.Time) ([]AccrualLatency, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	WITH finished AS (
+		SELECT COALESCE(o.merchant_id, 0) AS merchant_id,
+		       EXTRACT(EPOCH FROM MIN(e.created_at) - o.uploaded_at) AS seconds
+		FROM orders o JOIN order_status_events e ON e.orderId = o.orderId
+		WHERE e.status IN ('PROCESSED', 'INVALID') AND o.uploaded_at >= $1
+		GROUP BY o.orderId, o.merchant_id, o.uploaded_at)
+	SELECT f.merchant_id, COALESCE(m.name, ''), COUNT(*), AVG(f.seconds),
+	       percentile_cont(0.5) WITHIN GROUP (ORDER BY f.seconds),
+	       percentile_cont(0.95) WITHIN GROUP (ORDER BY f.seconds),
+	       MAX(f.seconds)
+	FROM finished f LEFT JOIN merchants m ON m.id = f.merchant_id
+	GROUP BY f.merchant_id, m.name
+	ORDER BY f.merchant_id`, since)
+	if err != nil {
+		return []AccrualLatency{}, fmt.Errorf("failed to query accrual latency: %w", err)
+	}
+	defer rows.Close()
+
+	stats := []AccrualLatency{}
+
+	for rows.Next() {
+		var l AccrualLatency
+
+		if err := rows.Scan(&l.MerchantID, &l.Merchant, &l.Orders, &l.AvgSeconds, &l.P50Seconds, &l.P95Seconds, &l.MaxSeconds); err != nil {
+			return []AccrualLatency{}, fmt.Errorf("failed to scan accrual latency: %w", err)
+		}
+
+		stats = append(stats, l)
+	}
+	if err := rows.Err(); err != nil {
+		return []AccrualLatency{}, fmt.Errorf("failed to get accrual latency: %w", err)
+	}
+
+	return stats, nil
+}
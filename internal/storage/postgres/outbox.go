@@ -0,0 +1,152 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"time"
+)
+
+const (
+	WebhookPointsWithdrawn = "points.withdrawn"
+	WebhookAccrualCredited = "accrual.credited"
+
+	WebhookPending   = "PENDING"
+	WebhookDelivered = "DELIVERED"
+	WebhookDead      = "DEAD"
+)
+
+type WebhookDelivery struct {
+	ID         int64           `json:"id" db:"id"`
+	MerchantID int64           `json:"merchant_id" db:"merchant_id"`
+	EventType  string          `json:"event_type" db:"event_type"`
+	Payload    json.RawMessage `json:"payload" db:"payload"`
+	Status     string          `json:"status" db:"status"`
+	Attempts   int             `json:"attempts" db:"attempts"`
+	LastError  string          `json:"last_error,omitempty" db:"last_error"`
+	CreatedAt  time.Time       `json:"created_at" db:"created_at"`
+	URL        string          `json:"-"`
+	Secret     string          `json:"-"`
+}
+
+// enqueueWebhook adds a webhook for the merchant to the outbox if the merchant
+// has a webhook URL configured. It is meant to run in the same transaction as
+// the change it reports.
+func enqueueWebhook(ctx context.Context, db execer, merchantID int64, eventType string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+
+	_, err = db.ExecContext(ctx, `
+	INSERT INTO webhook_outbox(merchant_id, event_type, payload)
+	SELECT id, $2, $3 FROM merchants WHERE id = $1 AND webhook_url <> ''`, merchantID, eventType, payload)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue webhook: %w", err)
+	}
+
+	return nil
+}
+
+// ClaimWebhooks returns up to limit webhooks that are due and hides them from
+// other dispatchers for lease, so every replica can run a dispatcher.
+func (s *Storage) ClaimWebhooks(ctx context.Context, limit int, lease time.Duration) ([]WebhookDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	UPDATE webhook_outbox o SET next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+	FROM merchants m
+	WHERE m.id = o.merchant_id AND o.id IN (
+		SELECT id FROM webhook_outbox
+		WHERE status = 'PENDING' AND next_attempt_at <= NOW()
+		ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED)
+	RETURNING o.id, o.merchant_id, o.event_type, o.payload, o.status, o.attempts, o.created_at, m.webhook_url, m.webhook_secret`,
+		limit, lease.Seconds())
+	if err != nil {
+		return []WebhookDelivery{}, fmt.Errorf("failed to claim webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+
+	for rows.Next() {
+		var d WebhookDelivery
+
+		if err := rows.Scan(&d.ID, &d.MerchantID, &d.EventType, &d.Payload, &d.Status, &d.Attempts, &d.CreatedAt, &d.URL, &d.Secret); err != nil {
+			return []WebhookDelivery{}, fmt.Errorf("failed to scan webhook: %w", err)
+		}
+
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return []WebhookDelivery{}, fmt.Errorf("failed to claim webhooks: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+func (s *Storage) MarkWebhookDelivered(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE webhook_outbox SET status = 'DELIVERED', attempts = attempts + 1, delivered_at = NOW(), last_error = '' WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to mark webhook delivered: %w", err)
+	}
+
+	return nil
+}
+
+// MarkWebhookFailed records a failed attempt and schedules the next one, or
+// dead-letters the webhook when dead is set.
+func (s *Storage) MarkWebhookFailed(ctx context.Context, id int64, reason string, next time.Time, dead bool) error {
+	status := WebhookPending
+	if dead {
+		status = WebhookDead
+	}
+
+	_, err := s.db.ExecContext(ctx, `UPDATE webhook_outbox SET status = $1, attempts = attempts + 1, last_error = $2, next_attempt_at = $3 WHERE id = $4`,
+		status, reason, next, id)
+	if err != nil {
+		return fmt.Errorf("failed to mark webhook failed: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) GetDeadWebhooks(ctx context.Context) ([]WebhookDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT id, merchant_id, event_type, payload, status, attempts, last_error, created_at
+	FROM webhook_outbox WHERE status = 'DEAD' ORDER BY id ASC`)
+	if err != nil {
+		return []WebhookDelivery{}, fmt.Errorf("failed to query webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+
+	for rows.Next() {
+		var d WebhookDelivery
+
+		if err := rows.Scan(&d.ID, &d.MerchantID, &d.EventType, &d.Payload, &d.Status, &d.Attempts, &d.LastError, &d.CreatedAt); err != nil {
+			return []WebhookDelivery{}, fmt.Errorf("failed to scan webhook: %w", err)
+		}
+
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return []WebhookDelivery{}, fmt.Errorf("failed to get webhooks: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+// ReplayWebhook puts a dead-lettered webhook back in the queue.
+func (s *Storage) ReplayWebhook(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE webhook_outbox SET status = 'PENDING', attempts = 0, next_attempt_at = NOW() WHERE id = $1 AND status = 'DEAD'`, id)
+	if err != nil {
+		return fmt.Errorf("failed to replay webhook: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.ErrWebhookNotFound
+	}
+
+	return nil
+}
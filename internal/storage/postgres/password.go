@@ -0,0 +1,198 @@
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/tracing"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/validation"
+	"time"
+)
+
+// GetSessionVersion returns the version tokens of the user must carry to be
+// accepted. Disabled accounts have no valid sessions.
+func (s *Storage) GetSessionVersion(ctx context.Context, login string) (int, error) {
+	var version int
+	var disabled bool
+
+	err := s.db.QueryRowContext(ctx, `SELECT session_version, disabled_at IS NOT NULL FROM users WHERE login = $1`, login).Scan(&version, &disabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, storage.ErrUserNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to query session version: %w", err)
+	}
+	if disabled {
+		return 0, storage.ErrAccountDisabled
+	}
+
+	return version, nil
+}
+
+// ChangePassword sets a new password, revoking the tokens issued before, and
+// returns the new session version.
+func (s *Storage) ChangePassword(ctx context.Context, login, password string) (int, error) {
+	hash, err := newPasswordHash(ctx, password)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	version, err := setPassword(ctx, tx, login, hash)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := appendAudit(ctx, tx, login, AuditPasswordChanged, "user:"+login, map[string]any{}); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit password change: %w", err)
+	}
+
+	return version, nil
+}
+
+// CreatePasswordReset issues a reset token for the user valid for ttl. Only
+// a hash of the token is stored and earlier unused tokens stop working.
+func (s *Storage) CreatePasswordReset(ctx context.Context, login string, ttl time.Duration) (string, time.Time, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate reset token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE login = $1)`, login).Scan(&exists)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to check user existence: %w", err)
+	}
+	if !exists {
+		return "", time.Time{}, storage.ErrUserNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM password_resets WHERE user_login = $1 OR expires_at < now()`, login)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to delete old reset tokens: %w", err)
+	}
+
+	var expiresAt time.Time
+	err = tx.QueryRowContext(ctx, `
+	INSERT INTO password_resets(token_hash, user_login, expires_at) VALUES ($1, $2, now() + make_interval(secs => $3))
+	RETURNING expires_at`, hashResetToken(token), login, ttl.Seconds()).Scan(&expiresAt)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to insert reset token: %w", err)
+	}
+
+	err = appendAudit(ctx, tx, login, AuditPasswordResetRequested, "user:"+login, map[string]any{"expires_at": expiresAt})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to commit reset token: %w", err)
+	}
+
+	return token, expiresAt, nil
+}
+
+// ResetPassword sets a new password using a reset token, which can be used
+// once. All tokens of the user are revoked and a login lockout is lifted.
+func (s *Storage) ResetPassword(ctx context.Context, token, password string) (string, error) {
+	hash, err := newPasswordHash(ctx, password)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var login string
+	err = tx.QueryRowContext(ctx, `
+	DELETE FROM password_resets WHERE token_hash = $1 AND expires_at > now()
+	RETURNING user_login`, hashResetToken(token)).Scan(&login)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", storage.ErrInvalidResetToken
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to consume reset token: %w", err)
+	}
+
+	if _, err := setPassword(ctx, tx, login, hash); err != nil {
+		return "", err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM login_attempts WHERE key = $1`, attemptPolicies[0].prefix+login)
+	if err != nil {
+		return "", fmt.Errorf("failed to reset login failures: %w", err)
+	}
+
+	if err := appendAudit(ctx, tx, login, AuditPasswordReset, "user:"+login, map[string]any{}); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit password reset: %w", err)
+	}
+
+	return login, nil
+}
+
+// newPasswordHash checks a new password against the policy and hashes it.
+func newPasswordHash(ctx context.Context, password string) (string, error) {
+	if err := validation.ValidatePassword(password); err != nil {
+		return "", err
+	}
+
+	_, span := tracing.Tracer().Start(ctx, "password.hash")
+	hash, err := validation.HashPassword(password)
+	span.End()
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	return hash, nil
+}
+
+func setPassword(ctx context.Context, tx *sql.Tx, login, hash string) (int, error) {
+	var version int
+
+	err := tx.QueryRowContext(ctx, `
+	UPDATE users SET password = $2, session_version = session_version + 1
+	WHERE login = $1 RETURNING session_version`, login, hash).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, storage.ErrUserNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to update password: %w", err)
+	}
+
+	return version, nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -5,19 +5,31 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"github.com/XSAM/otelsql"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/config"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/events"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/metrics"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/tracing"
 	"github.com/nglmq/gofermart-loyalty-programm/internal/validation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 	"log/slog"
+	"slices"
 	"time"
 )
 
+// SchemaVersion is the version of the schema New creates. Bump it whenever
+// the schema changes, so readiness checks catch replicas running against a
+// database migrated by a different release.
+const SchemaVersion = 11
+
 type Storage struct {
 	db *sql.DB
 }
 
 type Order struct {
+	ID         int64     `json:"-" db:"id"`
 	Number     string    `json:"number" db:"orderId"`
 	Status     string    `json:"status" db:"status"`
 	Accrual    float64   `json:"accrual,omitempty" db:"accrual"`
@@ -29,14 +41,20 @@ type Balance struct {
 	Withdrawn float64 `json:"withdrawn" db:"withdrawn"`
 }
 
+type UnfinishedOrder struct {
+	Number   string
+	Merchant Merchant
+}
+
 type Withdrawals struct {
+	ID          int64     `json:"-" db:"id"`
 	OrderID     string    `json:"order" db:"orderId"`
 	Sum         float64   `json:"sum" db:"amount"`
 	ProcessedAt time.Time `json:"processed_at" db:"processed_at"`
 }
 
 func New() (*Storage, error) {
-	db, err := sql.Open("pgx", config.DataBaseURL)
+	db, err := otelsql.Open("pgx", config.DataBaseURL, otelsql.WithAttributes(semconv.DBSystemPostgreSQL))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database connection: %w", err)
 	}
@@ -81,9 +99,291 @@ func New() (*Storage, error) {
 		return nil, fmt.Errorf("failed to create withdrawals table: %w", err)
 	}
 
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS order_status_events(
+	    id BIGSERIAL PRIMARY KEY,
+    	orderId TEXT NOT NULL REFERENCES orders (orderId) ON DELETE CASCADE,
+    	status TEXT NOT NULL,
+    	accrual FLOAT,
+    	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
+	ALTER TABLE order_status_events ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT 'poll';
+	CREATE INDEX IF NOT EXISTS order_status_events_order_idx ON order_status_events (orderId, created_at);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create order_status_events table: %w", err)
+	}
+
+	_, err = db.Exec(`
+	CREATE INDEX IF NOT EXISTS orders_user_uploaded_idx ON orders (user_login, uploaded_at, id);
+	CREATE INDEX IF NOT EXISTS withdrawals_user_processed_idx ON withdrawals (user_login, processed_at, id);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create list indexes: %w", err)
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS merchants(
+	    id SERIAL PRIMARY KEY,
+    	name TEXT NOT NULL UNIQUE,
+    	accrual_url TEXT NOT NULL,
+    	rate_limit FLOAT NOT NULL DEFAULT 0,
+    	burst INT NOT NULL DEFAULT 1,
+    	credentials TEXT NOT NULL DEFAULT '',
+    	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
+	ALTER TABLE merchants ADD COLUMN IF NOT EXISTS webhook_secret TEXT NOT NULL DEFAULT '';
+	ALTER TABLE orders ADD COLUMN IF NOT EXISTS merchant_id INT REFERENCES merchants (id) ON DELETE SET NULL;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create merchants table: %w", err)
+	}
+
+	_, err = db.Exec(`
+	ALTER TABLE merchants ADD COLUMN IF NOT EXISTS webhook_url TEXT NOT NULL DEFAULT '';
+	ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS merchant_id INT REFERENCES merchants (id) ON DELETE SET NULL;
+	CREATE TABLE IF NOT EXISTS webhook_outbox(
+	    id BIGSERIAL PRIMARY KEY,
+    	merchant_id INT NOT NULL REFERENCES merchants (id) ON DELETE CASCADE,
+    	event_type TEXT NOT NULL,
+    	payload JSONB NOT NULL,
+    	status TEXT NOT NULL DEFAULT 'PENDING',
+    	attempts INT NOT NULL DEFAULT 0,
+    	last_error TEXT NOT NULL DEFAULT '',
+    	next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    	delivered_at TIMESTAMP);
+	CREATE INDEX IF NOT EXISTS webhook_outbox_pending_idx ON webhook_outbox (next_attempt_at) WHERE status = 'PENDING';
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook_outbox table: %w", err)
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS domain_events(
+	    id BIGSERIAL PRIMARY KEY,
+    	type TEXT NOT NULL,
+    	user_login TEXT NOT NULL,
+    	payload JSONB NOT NULL,
+    	txid XID8 NOT NULL DEFAULT pg_current_xact_id(),
+    	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
+	CREATE TABLE IF NOT EXISTS event_cursors(
+	    name TEXT PRIMARY KEY,
+    	last_id BIGINT NOT NULL DEFAULT 0);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create domain_events table: %w", err)
+	}
+
+	// Cursors used to be event ids alone. An existing cursor resumes from the
+	// transaction of its last event; events sorting before it there were
+	// published already or are published again.
+	_, err = db.Exec(`
+	ALTER TABLE event_cursors ADD COLUMN IF NOT EXISTS last_txid XID8 NOT NULL DEFAULT '0';
+	ALTER TABLE event_cursors ADD COLUMN IF NOT EXISTS leased_until TIMESTAMPTZ NOT NULL DEFAULT '-infinity';
+	UPDATE event_cursors c SET last_txid = e.txid FROM domain_events e WHERE e.id = c.last_id AND c.last_txid = '0';
+	CREATE INDEX IF NOT EXISTS domain_events_feed_idx ON domain_events (txid, id);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add event cursor transaction ids: %w", err)
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS webhook_signatures(
+	    signature TEXT PRIMARY KEY,
+    	received_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook_signatures table: %w", err)
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS rate_limits(
+	    key TEXT PRIMARY KEY,
+    	tokens DOUBLE PRECISION NOT NULL,
+    	allowed BOOLEAN NOT NULL,
+    	updated_at TIMESTAMPTZ NOT NULL);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rate_limits table: %w", err)
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS login_attempts(
+	    key TEXT PRIMARY KEY,
+    	failures INT NOT NULL,
+    	last_failure TIMESTAMPTZ NOT NULL,
+    	locked_until TIMESTAMPTZ);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create login_attempts table: %w", err)
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS audit_log(
+	    id BIGSERIAL PRIMARY KEY,
+    	actor TEXT NOT NULL,
+    	action TEXT NOT NULL,
+    	target TEXT NOT NULL,
+    	details JSONB NOT NULL,
+    	created_at TIMESTAMPTZ NOT NULL DEFAULT now());
+	CREATE INDEX IF NOT EXISTS audit_log_target_idx ON audit_log (target, created_at);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create audit_log table: %w", err)
+	}
+
+	_, err = db.Exec(`
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS session_version INT NOT NULL DEFAULT 0;
+	CREATE TABLE IF NOT EXISTS password_resets(
+	    token_hash TEXT PRIMARY KEY,
+    	user_login TEXT NOT NULL REFERENCES users (login) ON DELETE CASCADE,
+    	expires_at TIMESTAMPTZ NOT NULL,
+    	created_at TIMESTAMPTZ NOT NULL DEFAULT now());
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create password_resets table: %w", err)
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS user_mfa(
+	    user_login TEXT PRIMARY KEY REFERENCES users (login) ON DELETE CASCADE,
+    	secret TEXT NOT NULL,
+    	enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    	last_step BIGINT NOT NULL DEFAULT 0,
+    	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    	enabled_at TIMESTAMPTZ);
+	CREATE TABLE IF NOT EXISTS mfa_recovery_codes(
+	    id BIGSERIAL PRIMARY KEY,
+    	user_login TEXT NOT NULL REFERENCES users (login) ON DELETE CASCADE,
+    	code_hash TEXT NOT NULL,
+    	used_at TIMESTAMPTZ);
+	CREATE INDEX IF NOT EXISTS mfa_recovery_codes_user_idx ON mfa_recovery_codes (user_login);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user_mfa table: %w", err)
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS user_identities(
+	    issuer TEXT NOT NULL,
+    	subject TEXT NOT NULL,
+    	user_login TEXT NOT NULL REFERENCES users (login) ON DELETE CASCADE,
+    	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    	PRIMARY KEY (issuer, subject),
+    	UNIQUE (issuer, user_login));
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user_identities table: %w", err)
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS api_keys(
+	    id BIGSERIAL PRIMARY KEY,
+    	user_login TEXT NOT NULL REFERENCES users (login) ON DELETE CASCADE,
+    	name TEXT NOT NULL,
+    	display TEXT NOT NULL,
+    	key_hash TEXT NOT NULL UNIQUE,
+    	scopes TEXT[] NOT NULL,
+    	rate_limit TEXT,
+    	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    	last_used_at TIMESTAMPTZ,
+    	revoked_at TIMESTAMPTZ);
+	CREATE INDEX IF NOT EXISTS api_keys_user_idx ON api_keys (user_login);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create api_keys table: %w", err)
+	}
+
+	_, err = db.Exec(`
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'customer' CHECK (role IN ('customer', 'support', 'admin'));
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMPTZ;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add user role columns: %w", err)
+	}
+
+	_, err = db.Exec(`
+	ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS ip TEXT NOT NULL DEFAULT '';
+	ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '';
+	ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS prev_hash TEXT NOT NULL DEFAULT '';
+	ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS hash TEXT NOT NULL DEFAULT '';
+	CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor, created_at);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add audit_log hash columns: %w", err)
+	}
+
+	if err := sealAuditLog(db); err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS audit_pending(
+	    id BIGSERIAL PRIMARY KEY,
+    	actor TEXT NOT NULL,
+    	action TEXT NOT NULL,
+    	target TEXT NOT NULL,
+    	details JSONB NOT NULL,
+    	ip TEXT NOT NULL DEFAULT '',
+    	user_agent TEXT NOT NULL DEFAULT '',
+    	created_at TIMESTAMPTZ NOT NULL);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create audit_pending table: %w", err)
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_version(
+	    version INT PRIMARY KEY,
+    	applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+
+	_, err = db.Exec(`INSERT INTO schema_version(version) VALUES ($1) ON CONFLICT DO NOTHING`, SchemaVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record schema version: %w", err)
+	}
+
+	metrics.RegisterDB(db)
+
 	return &Storage{db: db}, nil
 }
 
+// Open connects to a database New has already set up. Unlike New it runs no
+// DDL and does not seal the audit log, so tools can read a live database
+// without taking its locks or migrating it.
+func Open() (*Storage, error) {
+	db, err := sql.Open("pgx", config.DataBaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database connection: %w", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	return &Storage{db: db}, nil
+}
+
+// Ping checks that the database is reachable.
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// GetSchemaVersion returns the newest schema version applied to the database.
+func (s *Storage) GetSchemaVersion(ctx context.Context) (int, error) {
+	var version int
+
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query schema version: %w", err)
+	}
+
+	return version, nil
+}
+
 func (s *Storage) SaveUser(ctx context.Context, login, password string) error {
 	var exists bool
 
@@ -92,51 +392,101 @@ func (s *Storage) SaveUser(ctx context.Context, login, password string) error {
 		return fmt.Errorf("failed to check user existence: %w", err)
 	}
 	if exists {
-		slog.Error("user already exists", login)
+		slog.InfoContext(ctx, "user already exists", "user", login)
 		return fmt.Errorf("%w", storage.ErrLoginAlreadyExists)
 	}
 
-	stmt, err := s.db.PrepareContext(ctx, `INSERT INTO users(login, password) VALUES ($1, $2)`)
+	password, err = newPasswordHash(ctx, password)
 	if err != nil {
-		return fmt.Errorf("failed to prepare insert statement: %w", err)
+		return err
 	}
-	defer stmt.Close()
 
-	password, err = validation.HashPassword(password)
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to hash password: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
+
+	var createdAt time.Time
 
-	_, err = stmt.ExecContext(ctx, login, password)
+	err = tx.QueryRowContext(ctx, `INSERT INTO users(login, password) VALUES ($1, $2) RETURNING created_at`, login, password).Scan(&createdAt)
 	if err != nil {
 		return fmt.Errorf("failed to insert user: %w", err)
 	}
 
+	err = appendEvent(ctx, tx, EventUserRegistered, login, map[string]any{"login": login, "registered_at": createdAt})
+	if err != nil {
+		return err
+	}
+
+	if err := appendAudit(ctx, tx, login, AuditUserRegistered, "user:"+login, map[string]any{}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit user: %w", err)
+	}
+
 	return nil
 }
 
+// GetUser checks the password of a user. A hash made with an outdated
+// algorithm or parameters is replaced while the password is at hand.
 func (s *Storage) GetUser(ctx context.Context, login, password string) (string, error) {
 	var correctPassword string
-	var userExists bool
+	var disabled bool
 
-	err := s.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE login  =  $1)`, login).Scan(&userExists)
-	if err != nil {
+	found := true
+	err := s.db.QueryRowContext(ctx, `SELECT password, disabled_at IS NOT NULL FROM users WHERE login = $1`, login).Scan(&correctPassword, &disabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		found, correctPassword = false, dummyHash()
+	} else if err != nil {
+		return "", fmt.Errorf("failed to query user: %w", err)
+	}
+
+	_, span := tracing.Tracer().Start(ctx, "password.verify")
+	ok := validation.CheckPassword(password, correctPassword)
+	span.End()
+	if !found {
 		return "", storage.ErrUserNotFound
 	}
+	if !ok {
+		return "", storage.ErrIncorrectPassword
+	}
+	if disabled {
+		return "", storage.ErrAccountDisabled
+	}
+
+	if validation.NeedsRehash(correctPassword) {
+		if err := s.rehashPassword(ctx, login, password, correctPassword); err != nil {
+			slog.WarnContext(ctx, "failed to upgrade password hash", "user", login, "error", err)
+		}
+	}
+
+	return login, nil
+}
 
-	err = s.db.QueryRowContext(ctx, `SELECT password FROM users WHERE login = $1`, login).Scan(&correctPassword)
+// rehashPassword replaces the hash of a user unless it changed since it was
+// read. Sessions stay valid: the password is the same.
+func (s *Storage) rehashPassword(ctx context.Context, login, password, oldHash string) error {
+	_, span := tracing.Tracer().Start(ctx, "password.hash")
+	hash, err := validation.HashPassword(password)
+	span.End()
 	if err != nil {
-		return "", fmt.Errorf("failed to prepare select statement: %w", err)
+		return fmt.Errorf("failed to hash password: %w", err)
 	}
 
-	if !validation.CheckPassword(password, correctPassword) {
-		return "", storage.ErrIncorrectPassword
+	_, err = s.db.ExecContext(ctx, `UPDATE users SET password = $2 WHERE login = $1 AND password = $3`, login, hash, oldHash)
+	if err != nil {
+		return fmt.Errorf("failed to update password hash: %w", err)
 	}
 
-	return login, nil
+	slog.InfoContext(ctx, "password hash upgraded", "user", login)
+
+	return nil
 }
 
-func (s *Storage) LoadOrder(ctx context.Context, login, orderID string) error {
+func (s *Storage) LoadOrder(ctx context.Context, login, orderID string, merchantID int64) error {
 	var loadByLogin string
 
 	err := s.db.QueryRowContext(ctx, "SELECT user_login FROM orders WHERE orderId = $1", orderID).Scan(&loadByLogin)
@@ -145,29 +495,46 @@ func (s *Storage) LoadOrder(ctx context.Context, login, orderID string) error {
 	}
 
 	if loadByLogin == login {
-		slog.Info("order already loaded by this user", login)
+		slog.InfoContext(ctx, "order already loaded by this user", "user", login, "order", orderID)
 		return storage.ErrOrderAlreadyLoadedByUser
 	} else if loadByLogin != "" {
-		slog.Info("order already loaded by another user", loadByLogin)
+		slog.InfoContext(ctx, "order already loaded by another user", "user", login, "order", orderID)
 		return storage.ErrOrderAlreadyLoadedByAnotherUser
 	}
 
-	stmt, err := s.db.PrepareContext(ctx, `INSERT INTO orders(user_login, orderId) VALUES ($1, $2)`)
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to prepare insert statement: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	defer stmt.Close()
+	defer tx.Rollback()
 
-	_, err = stmt.ExecContext(ctx, login, orderID)
+	order := Order{Number: orderID, Status: "NEW"}
+
+	err = tx.QueryRowContext(ctx, `INSERT INTO orders(user_login, orderId, merchant_id) VALUES ($1, $2, $3) RETURNING uploaded_at`,
+		login, orderID, sql.NullInt64{Int64: merchantID, Valid: merchantID != 0}).Scan(&order.UploadedAt)
 	if err != nil {
 		return fmt.Errorf("failed to insert order: %w", err)
 	}
 
+	if err := appendStatusEvent(ctx, tx, orderID, order.Status, 0, StatusSourceUpload); err != nil {
+		return err
+	}
+
+	if err := appendEvent(ctx, tx, EventOrderUploaded, login, order); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit order: %w", err)
+	}
+
 	return nil
 }
 
-func (s *Storage) GetOrders(ctx context.Context, login string) ([]Order, error) {
-	rows, err := s.db.QueryContext(ctx, "SELECT orderId, status, accrual, uploaded_at FROM orders WHERE user_login = $1 ORDER BY uploaded_at ASC", login)
+func (s *Storage) GetOrders(ctx context.Context, login string, filter ListFilter) ([]Order, error) {
+	clauses, args := filter.where("uploaded_at", []any{login})
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id, orderId, status, accrual, uploaded_at FROM orders WHERE user_login = $1"+clauses, args...)
 	if err != nil {
 		return []Order{}, fmt.Errorf("failed to query orders: %w", err)
 	}
@@ -179,7 +546,7 @@ func (s *Storage) GetOrders(ctx context.Context, login string) ([]Order, error)
 		var order Order
 		var accrual sql.NullFloat64
 
-		if err := rows.Scan(&order.Number, &order.Status, &accrual, &order.UploadedAt); err != nil {
+		if err := rows.Scan(&order.ID, &order.Number, &order.Status, &accrual, &order.UploadedAt); err != nil {
 			return []Order{}, fmt.Errorf("failed to scan order: %w", err)
 		}
 		if accrual.Valid {
@@ -252,77 +619,254 @@ func (s *Storage) UpdateBalancePlus(ctx context.Context, amount float64, orderID
 	return nil
 }
 
+// UpdateOrderStatus overrides the order status without touching the balance
+// and records the change as made by an administrator.
 func (s *Storage) UpdateOrderStatus(ctx context.Context, accrual float64, status, orderID string) error {
-	stmt, err := s.db.PrepareContext(ctx, `UPDATE orders SET accrual = $1, status = $2  WHERE orderId = $3`)
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to prepare update statement: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	defer stmt.Close()
+	defer tx.Rollback()
 
-	_, err = stmt.ExecContext(ctx, accrual, status, orderID)
+	res, err := tx.ExecContext(ctx, `UPDATE orders SET accrual = $1, status = $2  WHERE orderId = $3`, accrual, status, orderID)
 	if err != nil {
 		return fmt.Errorf("failed to update status: %w", err)
 	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.ErrOrderNotFound
+	}
+
+	if err := appendStatusEvent(ctx, tx, orderID, status, accrual, StatusSourceAdmin); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit status: %w", err)
+	}
 
 	return nil
 }
 
-func (s *Storage) GetUnfinishedOrders() ([]string, error) {
-	rows, err := s.db.Query(`SELECT orderId FROM orders WHERE status IN ('NEW', 'REGISTERED', 'PROCESSING')`)
+// ApplyAccrual moves the order to the status reported by the accrual system and,
+// when it becomes PROCESSED, credits the accrual to the owner's balance. It reports
+// false if the order was already final, so repeated updates never credit twice.
+// source tells how the status was learned and is kept in the order history.
+// merchantID is the merchant whose accrual system reported the status, 0 for
+// the default one; orders of other accrual systems are refused.
+func (s *Storage) ApplyAccrual(ctx context.Context, merchantID int64, accrual float64, status, orderID, source string) (bool, error) {
+	if !slices.Contains(AccrualStatuses, status) {
+		return false, storage.ErrInvalidOrderStatus
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var login, current string
+	var owner sql.NullInt64
+
+	err = tx.QueryRowContext(ctx, `SELECT user_login, status, merchant_id FROM orders WHERE orderId = $1 FOR UPDATE`, orderID).Scan(&login, &current, &owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, storage.ErrOrderNotFound
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to query order: %w", err)
+	}
+
+	if owner.Int64 != merchantID {
+		return false, storage.ErrForeignOrder
+	}
+
+	if current == "PROCESSED" || current == "INVALID" {
+		return false, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE orders SET accrual = $1, status = $2 WHERE orderId = $3`, accrual, status, orderID)
+	if err != nil {
+		return false, fmt.Errorf("failed to update status: %w", err)
+	}
+
+	if current != status {
+		if err := appendStatusEvent(ctx, tx, orderID, status, accrual, source); err != nil {
+			return false, err
+		}
+
+		err = notify(ctx, tx, events.OrderStatusChanged, login, Order{Number: orderID, Status: status, Accrual: accrual})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if status == "PROCESSED" && accrual > 0 {
+		var balance Balance
+
+		err = tx.QueryRowContext(ctx, `UPDATE users SET current_balance = current_balance + $1 WHERE login = $2 RETURNING current_balance, withdrawn`, accrual, login).
+			Scan(&balance.Current, &balance.Withdrawn)
+		if err != nil {
+			return false, fmt.Errorf("failed to update balance: %w", err)
+		}
+
+		if err := notify(ctx, tx, events.BalanceChanged, login, balance); err != nil {
+			return false, err
+		}
+
+		credit := Order{Number: orderID, Status: status, Accrual: accrual}
+		if err := appendEvent(ctx, tx, EventAccrualCredited, login, credit); err != nil {
+			return false, err
+		}
+
+		if owner.Valid {
+			if err := enqueueWebhook(ctx, tx, owner.Int64, WebhookAccrualCredited, credit); err != nil {
+				return false, err
+			}
+		}
+
+		err = appendAudit(ctx, tx, AuditActorSystem, AuditBalanceCredited, "user:"+login, map[string]any{
+			"order":   orderID,
+			"amount":  accrual,
+			"balance": balance.Current,
+			"source":  source,
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit accrual: %w", err)
+	}
+	if status == "PROCESSED" && accrual > 0 {
+		metrics.AddPointsCredited(accrual)
+	}
+
+	return current != status, nil
+}
+
+// SaveWebhookSignature remembers a webhook signature and reports false if it was
+// already seen, which means the request is a replay.
+func (s *Storage) SaveWebhookSignature(ctx context.Context, signature string) (bool, error) {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM webhook_signatures WHERE received_at < NOW() - INTERVAL '1 day'`)
+	if err != nil {
+		return false, fmt.Errorf("failed to clean up webhook signatures: %w", err)
+	}
+
+	res, err := s.db.ExecContext(ctx, `INSERT INTO webhook_signatures(signature) VALUES ($1) ON CONFLICT DO NOTHING`, signature)
+	if err != nil {
+		return false, fmt.Errorf("failed to save webhook signature: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to save webhook signature: %w", err)
+	}
+
+	return n == 1, nil
+}
+
+func (s *Storage) GetUnfinishedOrders() ([]UnfinishedOrder, error) {
+	rows, err := s.db.Query(`
+	SELECT o.orderId, COALESCE(o.merchant_id, 0), COALESCE(m.name, ''), COALESCE(m.accrual_url, ''),
+	       COALESCE(m.credentials, ''), COALESCE(m.webhook_secret, ''), COALESCE(m.rate_limit, 0), COALESCE(m.burst, 1)
+	FROM orders o LEFT JOIN merchants m ON m.id = o.merchant_id
+	WHERE o.status IN ('NEW', 'REGISTERED', 'PROCESSING')`)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return []string{}, fmt.Errorf("failed to query orders: %w", err)
+		return []UnfinishedOrder{}, fmt.Errorf("failed to query orders: %w", err)
 	}
 	defer rows.Close()
 
-	var orders []string
+	var orders []UnfinishedOrder
 
 	for rows.Next() {
-		var orderID string
+		var order UnfinishedOrder
 
-		if err := rows.Scan(&orderID); err != nil {
-			return []string{}, fmt.Errorf("failed to scan order: %w", err)
+		if err := rows.Scan(&order.Number, &order.Merchant.ID, &order.Merchant.Name, &order.Merchant.AccrualURL,
+			&order.Merchant.Credentials, &order.Merchant.WebhookSecret, &order.Merchant.RateLimit, &order.Merchant.Burst); err != nil {
+			return []UnfinishedOrder{}, fmt.Errorf("failed to scan order: %w", err)
 		}
-		orders = append(orders, orderID)
+		orders = append(orders, order)
 	}
 	if err := rows.Err(); err != nil {
-		return []string{}, fmt.Errorf("failed to get orders: %w", err)
+		return []UnfinishedOrder{}, fmt.Errorf("failed to get orders: %w", err)
 	}
 
 	return orders, nil
 }
 
-func (s *Storage) RequestWithdraw(ctx context.Context, login string, amount float64, orderID string) error {
-	var balance float64
+func (s *Storage) RequestWithdraw(ctx context.Context, login string, amount float64, orderID string, merchantID int64) error {
+	if !validation.ValidSum(amount) {
+		return storage.ErrInvalidSum
+	}
 
-	err := s.db.QueryRowContext(ctx, "SELECT current_balance FROM users WHERE login  =  $1", login).Scan(&balance)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var balance Balance
+
+	err = tx.QueryRowContext(ctx, "SELECT current_balance, withdrawn FROM users WHERE login  =  $1 FOR UPDATE", login).Scan(&balance.Current, &balance.Withdrawn)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return fmt.Errorf("failed to query balance: %w", err)
 	}
-	if balance < amount {
+	if balance.Current < amount {
 		return storage.ErrNotEnoughBalance
 	}
 
-	stmt, err := s.db.PrepareContext(ctx, `INSERT INTO withdrawals(user_login, amount, orderId) VALUES  ($1,  $2,  $3)`)
-	if err != nil {
-		return fmt.Errorf("failed to prepare insert statement: %w", err)
-	}
-	defer stmt.Close()
+	withdrawal := Withdrawals{OrderID: orderID, Sum: amount}
 
-	_, err = stmt.ExecContext(ctx, login, amount, orderID)
+	err = tx.QueryRowContext(ctx, `INSERT INTO withdrawals(user_login, amount, orderId, merchant_id) VALUES  ($1,  $2,  $3, $4) RETURNING processed_at`,
+		login, amount, orderID, sql.NullInt64{Int64: merchantID, Valid: merchantID != 0}).Scan(&withdrawal.ProcessedAt)
 	if err != nil {
 		return fmt.Errorf("failed to insert withdrawal: %w", err)
 	}
 
-	err = s.UpdateBalanceMinus(ctx, login, amount)
+	err = tx.QueryRowContext(ctx, `UPDATE users SET current_balance = current_balance - $1, withdrawn  =  withdrawn  +  $1 WHERE login  =  $2 RETURNING current_balance, withdrawn`, amount, login).
+		Scan(&balance.Current, &balance.Withdrawn)
 	if err != nil {
 		return fmt.Errorf("failed to update balance: %w", err)
 	}
 
+	if err := appendEvent(ctx, tx, EventPointsWithdrawn, login, withdrawal); err != nil {
+		return err
+	}
+
+	if merchantID != 0 {
+		if err := enqueueWebhook(ctx, tx, merchantID, WebhookPointsWithdrawn, withdrawal); err != nil {
+			return err
+		}
+	}
+
+	if err := notify(ctx, tx, events.WithdrawalCompleted, login, withdrawal); err != nil {
+		return err
+	}
+	if err := notify(ctx, tx, events.BalanceChanged, login, balance); err != nil {
+		return err
+	}
+
+	err = appendAudit(ctx, tx, login, AuditBalanceWithdrawn, "user:"+login, map[string]any{
+		"order":   orderID,
+		"amount":  amount,
+		"balance": balance.Current,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit withdrawal: %w", err)
+	}
+	metrics.AddPointsWithdrawn(amount)
+
 	return nil
 }
 
-func (s *Storage) GetWithdrawals(ctx context.Context, login string) ([]Withdrawals, error) {
-	rows, err := s.db.QueryContext(ctx, `SELECT orderId, amount, processed_at FROM withdrawals WHERE user_login = $1 ORDER BY processed_at ASC`, login)
+func (s *Storage) GetWithdrawals(ctx context.Context, login string, filter ListFilter) ([]Withdrawals, error) {
+	clauses, args := filter.where("processed_at", []any{login})
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id, orderId, amount, processed_at FROM withdrawals WHERE user_login = $1`+clauses, args...)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return []Withdrawals{}, fmt.Errorf("failed to query withdrawals: %w", err)
 	}
@@ -333,7 +877,7 @@ func (s *Storage) GetWithdrawals(ctx context.Context, login string) ([]Withdrawa
 	for rows.Next() {
 		var withdrawal Withdrawals
 
-		if err := rows.Scan(&withdrawal.OrderID, &withdrawal.Sum, &withdrawal.ProcessedAt); err != nil {
+		if err := rows.Scan(&withdrawal.ID, &withdrawal.OrderID, &withdrawal.Sum, &withdrawal.ProcessedAt); err != nil {
 			return []Withdrawals{}, fmt.Errorf("failed to scan withdrawal: %w", err)
 		}
 
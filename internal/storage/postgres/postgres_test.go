@@ -0,0 +1,35 @@
+package postgres
+
+import (
+	"github.com/nglmq/gofermart-loyalty-programm/internal/config"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testStorage connects to the database in TEST_DATABASE_URI and skips the
+// test when it is not set. Tests use their own logins, so the database may
+// be shared.
+func testStorage(t *testing.T) *Storage {
+	t.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URI")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+
+	config.DataBaseURL = url
+	s, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.db.Close() })
+
+	return s
+}
+
+// testLogin returns a login no other test run uses.
+func testLogin() string {
+	return "test-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+}
@@ -0,0 +1,47 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// refilled is the token count of the existing bucket l after refilling it
+// at rate $3 up to burst $2.
+const refilled = `LEAST($2::DOUBLE PRECISION, l.tokens + EXTRACT(EPOCH FROM now() - l.updated_at)::DOUBLE PRECISION * $3::DOUBLE PRECISION)`
+
+var takeRateLimitQuery = fmt.Sprintf(`
+	INSERT INTO rate_limits AS l (key, tokens, allowed, updated_at) VALUES ($1, $2::DOUBLE PRECISION - 1, TRUE, now())
+	ON CONFLICT (key) DO UPDATE SET
+	    tokens = CASE WHEN %[1]s >= 1 THEN %[1]s - 1 ELSE %[1]s END,
+	    allowed = %[1]s >= 1,
+	    updated_at = now()
+	RETURNING tokens, allowed`, refilled)
+
+// TakeRateLimit refills the token bucket stored under key and takes a token
+// from it if there is one, in a single statement so concurrent replicas never
+// hand out the same token twice.
+func (s *Storage) TakeRateLimit(ctx context.Context, key string, rate float64, burst int) (float64, bool, error) {
+	var (
+		tokens  float64
+		allowed bool
+	)
+
+	err := s.db.QueryRowContext(ctx, takeRateLimitQuery, key, float64(burst), rate).Scan(&tokens, &allowed)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to take rate limit token: %w", err)
+	}
+
+	return tokens, allowed, nil
+}
+
+// DeleteIdleRateLimits forgets buckets not used for idle, which have refilled
+// by then.
+func (s *Storage) DeleteIdleRateLimits(ctx context.Context, idle time.Duration) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM rate_limits WHERE updated_at < $1`, time.Now().Add(-idle))
+	if err != nil {
+		return fmt.Errorf("failed to delete idle rate limits: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,306 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/events"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage"
+	"time"
+)
+
+// Roles of users, from least to most privileged. Support staff look after
+// customers; administrators also manage staff, merchants and webhooks.
+const (
+	RoleCustomer = "customer"
+	RoleSupport  = "support"
+	RoleAdmin    = "admin"
+)
+
+// Roles lists the roles in increasing order of privilege.
+var Roles = []string{RoleCustomer, RoleSupport, RoleAdmin}
+
+// UserProfile is what staff see of a user.
+type UserProfile struct {
+	Login      string     `json:"login"`
+	Role       string     `json:"role"`
+	Balance    Balance    `json:"balance"`
+	MFAEnabled bool       `json:"mfa_enabled"`
+	CreatedAt  time.Time  `json:"created_at"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+}
+
+// BalanceAdjustment is the payload of a BalanceAdjusted event.
+type BalanceAdjustment struct {
+	Amount float64 `json:"amount"`
+	Reason string  `json:"reason"`
+}
+
+// GetUserRole returns the role of the user.
+func (s *Storage) GetUserRole(ctx context.Context, login string) (string, error) {
+	var role string
+
+	err := s.db.QueryRowContext(ctx, `SELECT role FROM users WHERE login = $1`, login).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", storage.ErrUserNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to query user role: %w", err)
+	}
+
+	return role, nil
+}
+
+// GetUserProfile returns the account of the user as shown to staff.
+func (s *Storage) GetUserProfile(ctx context.Context, login string) (UserProfile, error) {
+	var (
+		p        UserProfile
+		disabled sql.NullTime
+	)
+
+	err := s.db.QueryRowContext(ctx, `
+	SELECT u.login, u.role, u.current_balance, u.withdrawn, COALESCE(m.enabled, FALSE), u.created_at, u.disabled_at
+	FROM users u LEFT JOIN user_mfa m ON m.user_login = u.login
+	WHERE u.login = $1`, login).
+		Scan(&p.Login, &p.Role, &p.Balance.Current, &p.Balance.Withdrawn, &p.MFAEnabled, &p.CreatedAt, &disabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UserProfile{}, storage.ErrAccountNotFound
+	}
+	if err != nil {
+		return UserProfile{}, fmt.Errorf("failed to query user: %w", err)
+	}
+
+	if disabled.Valid {
+		p.DisabledAt = &disabled.Time
+	}
+
+	return p, nil
+}
+
+// SetUserRole gives the user role on behalf of actor.
+func (s *Storage) SetUserRole(ctx context.Context, login, role, actor string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var previous string
+
+	err = tx.QueryRowContext(ctx, `SELECT role FROM users WHERE login = $1 FOR UPDATE`, login).Scan(&previous)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.ErrAccountNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query user role: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET role = $2 WHERE login = $1`, login, role); err != nil {
+		return fmt.Errorf("failed to update user role: %w", err)
+	}
+
+	err = appendAudit(ctx, tx, actor, AuditUserRoleChanged, "user:"+login, map[string]any{
+		"from": previous,
+		"to":   role,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit user role: %w", err)
+	}
+
+	return nil
+}
+
+// DisableUser stops the user from logging in and revokes their sessions and
+// API keys on behalf of actor. Disabling a disabled user changes nothing.
+func (s *Storage) DisableUser(ctx context.Context, login, actor string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var disabled bool
+
+	err = tx.QueryRowContext(ctx, `SELECT disabled_at IS NOT NULL FROM users WHERE login = $1 FOR UPDATE`, login).Scan(&disabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.ErrAccountNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query user: %w", err)
+	}
+	if disabled {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET disabled_at = now(), session_version = session_version + 1 WHERE login = $1`, login)
+	if err != nil {
+		return fmt.Errorf("failed to disable user: %w", err)
+	}
+
+	if err := appendAudit(ctx, tx, actor, AuditUserDisabled, "user:"+login, map[string]any{}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit user disabling: %w", err)
+	}
+
+	return nil
+}
+
+// EnableUser lets a disabled user log in again on behalf of actor. Sessions
+// revoked when the user was disabled stay revoked.
+func (s *Storage) EnableUser(ctx context.Context, login, actor string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var disabled sql.NullTime
+
+	err = tx.QueryRowContext(ctx, `SELECT disabled_at FROM users WHERE login = $1 FOR UPDATE`, login).Scan(&disabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.ErrAccountNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query user: %w", err)
+	}
+	if !disabled.Valid {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET disabled_at = NULL WHERE login = $1`, login); err != nil {
+		return fmt.Errorf("failed to enable user: %w", err)
+	}
+
+	if err := appendAudit(ctx, tx, actor, AuditUserEnabled, "user:"+login, map[string]any{"disabled_at": disabled.Time}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit user enabling: %w", err)
+	}
+
+	return nil
+}
+
+// AdjustBalance adds amount, which may be negative, to the balance of the
+// user on behalf of actor and returns the new balance. The balance cannot go
+// below zero.
+func (s *Storage) AdjustBalance(ctx context.Context, login string, amount float64, reason, actor string) (Balance, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Balance{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var balance Balance
+
+	err = tx.QueryRowContext(ctx, `SELECT current_balance, withdrawn FROM users WHERE login = $1 FOR UPDATE`, login).Scan(&balance.Current, &balance.Withdrawn)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Balance{}, storage.ErrAccountNotFound
+	}
+	if err != nil {
+		return Balance{}, fmt.Errorf("failed to query balance: %w", err)
+	}
+	if balance.Current+amount < 0 {
+		return Balance{}, storage.ErrNotEnoughBalance
+	}
+
+	err = tx.QueryRowContext(ctx, `UPDATE users SET current_balance = current_balance + $1 WHERE login = $2 RETURNING current_balance, withdrawn`, amount, login).
+		Scan(&balance.Current, &balance.Withdrawn)
+	if err != nil {
+		return Balance{}, fmt.Errorf("failed to update balance: %w", err)
+	}
+
+	adjustment := BalanceAdjustment{Amount: amount, Reason: reason}
+	if err := appendEvent(ctx, tx, EventBalanceAdjusted, login, adjustment); err != nil {
+		return Balance{}, err
+	}
+
+	if err := notify(ctx, tx, events.BalanceChanged, login, balance); err != nil {
+		return Balance{}, err
+	}
+
+	err = appendAudit(ctx, tx, actor, AuditBalanceAdjusted, "user:"+login, map[string]any{
+		"amount":  amount,
+		"reason":  reason,
+		"balance": balance.Current,
+	})
+	if err != nil {
+		return Balance{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Balance{}, fmt.Errorf("failed to commit balance adjustment: %w", err)
+	}
+
+	return balance, nil
+}
+
+// RecheckOrder prepares an order to be fetched again from its accrual system
+// on behalf of actor. An INVALID order is reopened as NEW; a PROCESSED order
+// was credited already and cannot be rechecked.
+func (s *Storage) RecheckOrder(ctx context.Context, orderID, actor string) (UnfinishedOrder, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return UnfinishedOrder{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	order := UnfinishedOrder{Number: orderID}
+	var login, status string
+
+	err = tx.QueryRowContext(ctx, `
+	SELECT o.user_login, o.status, COALESCE(o.merchant_id, 0), COALESCE(m.name, ''), COALESCE(m.accrual_url, ''),
+	       COALESCE(m.credentials, ''), COALESCE(m.rate_limit, 0), COALESCE(m.burst, 1)
+	FROM orders o LEFT JOIN merchants m ON m.id = o.merchant_id
+	WHERE o.orderId = $1
+	FOR UPDATE OF o`, orderID).
+		Scan(&login, &status, &order.Merchant.ID, &order.Merchant.Name, &order.Merchant.AccrualURL,
+			&order.Merchant.Credentials, &order.Merchant.RateLimit, &order.Merchant.Burst)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UnfinishedOrder{}, storage.ErrOrderNotFound
+	}
+	if err != nil {
+		return UnfinishedOrder{}, fmt.Errorf("failed to query order: %w", err)
+	}
+
+	if status == "PROCESSED" {
+		return UnfinishedOrder{}, storage.ErrOrderAlreadyProcessed
+	}
+
+	if status == "INVALID" {
+		if _, err := tx.ExecContext(ctx, `UPDATE orders SET status = 'NEW', accrual = NULL WHERE orderId = $1`, orderID); err != nil {
+			return UnfinishedOrder{}, fmt.Errorf("failed to reopen order: %w", err)
+		}
+
+		if err := appendStatusEvent(ctx, tx, orderID, "NEW", 0, StatusSourceAdmin); err != nil {
+			return UnfinishedOrder{}, err
+		}
+
+		if err := notify(ctx, tx, events.OrderStatusChanged, login, Order{Number: orderID, Status: "NEW"}); err != nil {
+			return UnfinishedOrder{}, err
+		}
+	}
+
+	err = appendAudit(ctx, tx, actor, AuditOrderRechecked, "order:"+orderID, map[string]any{
+		"user":   login,
+		"status": status,
+	})
+	if err != nil {
+		return UnfinishedOrder{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return UnfinishedOrder{}, fmt.Errorf("failed to commit order recheck: %w", err)
+	}
+
+	return order, nil
+}
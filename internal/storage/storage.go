@@ -1,6 +1,10 @@
 package storage
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 var (
 	ErrLoginAlreadyExists              = errors.New("login	already exists")
@@ -13,4 +17,38 @@ var (
 	ErrNotEnoughBalance                = errors.New("not enough balance")
 	ErrNoWithdrawalsFound              = errors.New("no withdrawals found")
 	ErrTooManyRequests                 = errors.New("too many requests")
+	ErrMerchantNotFound                = errors.New("merchant not found")
+	ErrMerchantAlreadyExists           = errors.New("merchant already exists")
+	ErrWebhookSecretRequired           = errors.New("webhook secret required")
+	ErrWebhookNotFound                 = errors.New("webhook not found")
+	ErrLoginLocked                     = errors.New("too many failed login attempts")
+	ErrNoLoginFailures                 = errors.New("no failed login attempts")
+	ErrInvalidResetToken               = errors.New("invalid or expired password reset token")
+	ErrSessionRevoked                  = errors.New("session revoked")
+	ErrMFANotEnrolled                  = errors.New("two-factor authentication is not enabled")
+	ErrMFAAlreadyEnabled               = errors.New("two-factor authentication is already enabled")
+	ErrInvalidMFACode                  = errors.New("invalid two-factor code")
+	ErrMFARequired                     = errors.New("two-factor confirmation required")
+	ErrAPIKeyNotFound                  = errors.New("api key not found")
+	ErrInvalidAPIKey                   = errors.New("invalid or revoked api key")
+	ErrInsufficientScope               = errors.New("api key lacks the required scope")
+	ErrAccountNotFound                 = errors.New("account not found")
+	ErrAccountDisabled                 = errors.New("account disabled")
+	ErrOrderAlreadyProcessed           = errors.New("order already processed")
+	ErrInvalidOrderStatus              = errors.New("invalid order status")
+	ErrForeignOrder                    = errors.New("order belongs to another accrual system")
+	ErrInvalidSum                      = errors.New("withdrawal sum must be positive")
 )
+
+// LockedError is returned while a login or client IP is locked out.
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("login locked until %s", e.Until.Format(time.RFC3339))
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrLoginLocked
+}
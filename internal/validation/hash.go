@@ -1,17 +1,236 @@
 package validation
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
+	"strconv"
+	"strings"
 )
 
+// PasswordHasher turns passwords into self-describing hash strings.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches a hash made by this hasher.
+	Verify(password, hash string) bool
+	// Current reports whether hash was made with this hasher's parameters.
+	Current(hash string) bool
+}
+
+var hasher PasswordHasher = NewArgon2id(Argon2idParams{})
+
+// legacy verifies hashes of every supported algorithm, so hashes made before
+// the hasher was switched keep working until they are upgraded.
+var legacy = []PasswordHasher{NewArgon2id(Argon2idParams{}), Bcrypt{}}
+
+// SetupPasswordHasher selects the hasher for new passwords from spec:
+// "argon2id[:m=<KiB>,t=<iterations>,p=<threads>]" or "bcrypt[:cost=<cost>]".
+// Omitted parameters keep their defaults.
+func SetupPasswordHasher(spec string) error {
+	name, params, _ := strings.Cut(spec, ":")
+
+	values := make(map[string]int)
+	for _, param := range strings.Split(params, ",") {
+		if param == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(param, "=")
+		n, err := strconv.Atoi(value)
+		if !ok || err != nil || n <= 0 {
+			return fmt.Errorf("invalid password hasher parameter %q", param)
+		}
+		values[key] = n
+	}
+
+	switch name {
+	case "argon2id":
+		if values["p"] > 255 {
+			return fmt.Errorf("argon2id parallelism must be at most 255")
+		}
+		p := Argon2idParams{Memory: uint32(values["m"]), Iterations: uint32(values["t"]), Parallelism: uint8(values["p"])}
+		delete(values, "m")
+		delete(values, "t")
+		delete(values, "p")
+		hasher = NewArgon2id(p)
+	case "bcrypt":
+		cost := values["cost"]
+		delete(values, "cost")
+		if cost != 0 && (cost < bcrypt.MinCost || cost > bcrypt.MaxCost) {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		hasher = Bcrypt{Cost: cost}
+	default:
+		return fmt.Errorf("unknown password hasher %q", name)
+	}
+
+	for key := range values {
+		return fmt.Errorf("unknown %s parameter %q", name, key)
+	}
+
+	return nil
+}
+
+// HashPassword hashes a new password with the configured hasher.
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 11)
+	return hasher.Hash(password)
+}
+
+// CheckPassword reports whether password matches hash, whatever supported
+// algorithm made it.
+func CheckPassword(password, hash string) bool {
+	for _, h := range legacy {
+		if h.Verify(password, hash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NeedsRehash reports whether hash should be replaced by one made with the
+// configured hasher and parameters.
+func NeedsRehash(hash string) bool {
+	return !hasher.Current(hash)
+}
+
+// Argon2idParams are the cost parameters of Argon2id. Zero values take the
+// OWASP recommended minimum: 19 MiB of memory, 2 iterations, 1 thread.
+type Argon2idParams struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// Argon2id hashes into PHC strings such as
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>.
+type Argon2id struct {
+	params Argon2idParams
+}
+
+func NewArgon2id(p Argon2idParams) Argon2id {
+	if p.Memory == 0 {
+		p.Memory = 19 * 1024
+	}
+	if p.Iterations == 0 {
+		p.Iterations = 2
+	}
+	if p.Parallelism == 0 {
+		p.Parallelism = 1
+	}
+
+	return Argon2id{params: p}
+}
+
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	p := a.params
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, argon2KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a Argon2id) Verify(password, hash string) bool {
+	p, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return false
+	}
+
+	other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+func (a Argon2id) Current(hash string) bool {
+	p, _, key, err := parseArgon2id(hash)
+
+	return err == nil && p == a.params && len(key) == argon2KeyLength
+}
+
+var errNotArgon2id = errors.New("not an argon2id hash")
+
+func parseArgon2id(hash string) (Argon2idParams, []byte, []byte, error) {
+	var p Argon2idParams
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return p, nil, nil, errNotArgon2id
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, errNotArgon2id
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, errNotArgon2id
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, errNotArgon2id
+	}
+
+	return p, salt, key, nil
+}
+
+// Bcrypt hashes into modular crypt strings such as $2a$11$<salt+hash>. It only
+// looks at the first 72 bytes of a password and refuses longer ones.
+type Bcrypt struct {
+	Cost int
+}
+
+const (
+	defaultBcryptCost      = 11
+	bcryptMaxPasswordBytes = 72
+)
+
+// errBcryptPasswordTooLong is what ValidatePassword and Bcrypt.Hash refuse
+// passwords bcrypt cannot hash with.
+var errBcryptPasswordTooLong = &PasswordError{
+	Reason: fmt.Sprintf("Password must be at most %d bytes long", bcryptMaxPasswordBytes),
+}
+
+func (b Bcrypt) cost() int {
+	if b.Cost == 0 {
+		return defaultBcryptCost
+	}
+
+	return b.Cost
+}
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	if len(password) > bcryptMaxPasswordBytes {
+		return "", errBcryptPasswordTooLong
+	}
+
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), b.cost())
 
 	return string(bytes), err
 }
 
-func CheckPassword(password, hash string) bool {
+func (b Bcrypt) Verify(password, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 
 	return err == nil
 }
+
+func (b Bcrypt) Current(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+
+	return err == nil && cost == b.cost()
+}
@@ -1,5 +1,12 @@
 package validation
 
+import "math"
+
+// ValidSum reports whether sum can be withdrawn: a finite amount above zero.
+func ValidSum(sum float64) bool {
+	return sum > 0 && !math.IsInf(sum, 1)
+}
+
 func Valid(number int) bool {
 	return (number%10+checksum(number/10))%10 == 0
 }
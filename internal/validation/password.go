@@ -0,0 +1,87 @@
+package validation
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrWeakPassword is wrapped by every PasswordError.
+var ErrWeakPassword = errors.New("password does not meet the policy")
+
+// PasswordError tells the user why a new password was refused.
+type PasswordError struct {
+	Reason string
+}
+
+func (e *PasswordError) Error() string {
+	return e.Reason
+}
+
+func (e *PasswordError) Unwrap() error {
+	return ErrWeakPassword
+}
+
+type passwordPolicy struct {
+	minLength int
+	maxLength int
+	breached  map[string]struct{}
+}
+
+var policy = passwordPolicy{minLength: 8, maxLength: 128}
+
+// SetupPasswordPolicy sets the rules ValidatePassword applies. Passwords
+// listed in breachedFile, one per line, are refused regardless of case.
+func SetupPasswordPolicy(minLength, maxLength int, breachedFile string) error {
+	if minLength < 1 || maxLength < minLength {
+		return fmt.Errorf("invalid password length limits %d..%d", minLength, maxLength)
+	}
+
+	p := passwordPolicy{minLength: minLength, maxLength: maxLength}
+
+	if breachedFile != "" {
+		f, err := os.Open(breachedFile)
+		if err != nil {
+			return fmt.Errorf("failed to open breached passwords file: %w", err)
+		}
+		defer f.Close()
+
+		p.breached = make(map[string]struct{})
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				p.breached[strings.ToLower(line)] = struct{}{}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("failed to read breached passwords file: %w", err)
+		}
+	}
+
+	policy = p
+
+	return nil
+}
+
+// ValidatePassword checks a new password against the policy.
+func ValidatePassword(password string) error {
+	length := utf8.RuneCountInString(password)
+
+	if length < policy.minLength {
+		return &PasswordError{Reason: fmt.Sprintf("Password must be at least %d characters long", policy.minLength)}
+	}
+	if length > policy.maxLength {
+		return &PasswordError{Reason: fmt.Sprintf("Password must be at most %d characters long", policy.maxLength)}
+	}
+	if _, ok := hasher.(Bcrypt); ok && len(password) > bcryptMaxPasswordBytes {
+		return errBcryptPasswordTooLong
+	}
+	if _, ok := policy.breached[strings.ToLower(password)]; ok {
+		return &PasswordError{Reason: "Password is known from data breaches, choose another one"}
+	}
+
+	return nil
+}
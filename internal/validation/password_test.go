@@ -0,0 +1,51 @@
+package validation
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidatePasswordBcryptLength(t *testing.T) {
+	defer func(h PasswordHasher) { hasher = h }(hasher)
+
+	tests := []struct {
+		name     string
+		hasher   string
+		password string
+		wantErr  bool
+	}{
+		{"argon2id accepts long passwords", "argon2id", strings.Repeat("a", 100), false},
+		{"bcrypt accepts 72 bytes", "bcrypt:cost=4", strings.Repeat("a", 72), false},
+		{"bcrypt refuses 73 bytes", "bcrypt:cost=4", strings.Repeat("a", 73), true},
+		{"bcrypt counts bytes, not characters", "bcrypt:cost=4", strings.Repeat("я", 40), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetupPasswordHasher(tt.hasher); err != nil {
+				t.Fatal(err)
+			}
+
+			err := ValidatePassword(tt.password)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("err = %v, want error: %v", err, tt.wantErr)
+			}
+			if err != nil {
+				var weak *PasswordError
+				if !errors.As(err, &weak) {
+					t.Errorf("err = %T, want *PasswordError", err)
+				}
+				return
+			}
+
+			hash, err := HashPassword(tt.password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !CheckPassword(tt.password, hash) {
+				t.Error("hash does not verify")
+			}
+		})
+	}
+}
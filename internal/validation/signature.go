@@ -0,0 +1,36 @@
+package validation
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// Sign returns the hex encoded HMAC-SHA256 of "<timestamp>.<body>".
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CheckSignature verifies a signature produced by Sign and rejects timestamps
+// further than tolerance away from now.
+func CheckSignature(secret, signature string, timestamp int64, body []byte, tolerance time.Duration) bool {
+	if secret == "" {
+		return false
+	}
+
+	diff := time.Since(time.Unix(timestamp, 0))
+	if diff > tolerance || diff < -tolerance {
+		return false
+	}
+
+	expected := Sign(secret, timestamp, body)
+
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
@@ -0,0 +1,150 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/health"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/validation"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	batchSize   = 50
+	lease       = time.Minute
+	maxAttempts = 10
+	maxBackoff  = time.Hour
+)
+
+// errNoSecret is returned for merchants without a webhook secret: an unsigned
+// delivery cannot be told apart from a forged one, so it is dead-lettered.
+var errNoSecret = errors.New("merchant has no webhook secret")
+
+type Outbox interface {
+	ClaimWebhooks(ctx context.Context, limit int, lease time.Duration) ([]postgres.WebhookDelivery, error)
+	MarkWebhookDelivered(ctx context.Context, id int64) error
+	MarkWebhookFailed(ctx context.Context, id int64, reason string, next time.Time, dead bool) error
+}
+
+// Dispatcher delivers the webhooks queued in the outbox to merchants.
+type Dispatcher struct {
+	outbox Outbox
+	client *http.Client
+}
+
+func NewDispatcher(outbox Outbox) *Dispatcher {
+	return &Dispatcher{
+		outbox: outbox,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Run delivers due webhooks every interval until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	health.Register("webhooks", interval)
+	defer health.Exit("webhooks")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			health.Beat("webhooks")
+			if err := d.dispatch(ctx); err != nil {
+				slog.Error("failed to dispatch webhooks", "error", err)
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context) error {
+	deliveries, err := d.outbox.ClaimWebhooks(ctx, batchSize, lease)
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range deliveries {
+		if err := d.deliver(ctx, delivery); err != nil {
+			attempts := delivery.Attempts + 1
+			dead := attempts >= maxAttempts || errors.Is(err, errNoSecret)
+
+			slog.Info("webhook delivery failed", "webhook", delivery.ID, "merchant", delivery.MerchantID, "attempts", attempts, "dead", dead, "error", err)
+
+			if err := d.outbox.MarkWebhookFailed(ctx, delivery.ID, err.Error(), time.Now().Add(backoff(attempts)), dead); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := d.outbox.MarkWebhookDelivered(ctx, delivery.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery postgres.WebhookDelivery) error {
+	if delivery.Secret == "" {
+		return errNoSecret
+	}
+
+	body, err := json.Marshal(struct {
+		ID        int64           `json:"id"`
+		Type      string          `json:"type"`
+		CreatedAt time.Time       `json:"created_at"`
+		Data      json.RawMessage `json:"data"`
+	}{delivery.ID, delivery.EventType, delivery.CreatedAt, delivery.Payload})
+	if err != nil {
+		return fmt.Errorf("error encoding webhook: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating webhook request: %w", err)
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gophermart-Event", delivery.EventType)
+	req.Header.Set("X-Gophermart-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Gophermart-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Gophermart-Signature", validation.Sign(delivery.Secret, timestamp, body))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending webhook: %w", err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("merchant responded with status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// backoff doubles the delay after every failed attempt, starting at 2 seconds.
+func backoff(attempts int) time.Duration {
+	if attempts >= 12 {
+		return maxBackoff
+	}
+
+	d := time.Second << attempts
+	if d > maxBackoff {
+		return maxBackoff
+	}
+
+	return d
+}
@@ -0,0 +1,67 @@
+package webhooks
+
+import (
+	"context"
+	"github.com/nglmq/gofermart-loyalty-programm/internal/storage/postgres"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type failure struct {
+	dead bool
+}
+
+type fakeOutbox struct {
+	deliveries []postgres.WebhookDelivery
+	delivered  []int64
+	failed     map[int64]failure
+}
+
+func (o *fakeOutbox) ClaimWebhooks(context.Context, int, time.Duration) ([]postgres.WebhookDelivery, error) {
+	return o.deliveries, nil
+}
+
+func (o *fakeOutbox) MarkWebhookDelivered(_ context.Context, id int64) error {
+	o.delivered = append(o.delivered, id)
+	return nil
+}
+
+func (o *fakeOutbox) MarkWebhookFailed(_ context.Context, id int64, _ string, _ time.Time, dead bool) error {
+	o.failed[id] = failure{dead: dead}
+	return nil
+}
+
+func TestDispatch(t *testing.T) {
+	var requests int
+	merchant := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("X-Gophermart-Signature") == "" {
+			t.Error("delivery is not signed")
+		}
+	}))
+	defer merchant.Close()
+
+	outbox := &fakeOutbox{
+		deliveries: []postgres.WebhookDelivery{
+			{ID: 1, URL: merchant.URL, Secret: "secret"},
+			{ID: 2, URL: merchant.URL},
+		},
+		failed: map[int64]failure{},
+	}
+
+	if err := NewDispatcher(outbox).dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if requests != 1 {
+		t.Errorf("requests = %d, want 1", requests)
+	}
+	if len(outbox.delivered) != 1 || outbox.delivered[0] != 1 {
+		t.Errorf("delivered = %v, want [1]", outbox.delivered)
+	}
+	if f, ok := outbox.failed[2]; !ok || !f.dead {
+		t.Errorf("delivery without a secret: failed = %+v, %v, want dead", f, ok)
+	}
+}